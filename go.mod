@@ -2,7 +2,11 @@ module github.com/vyrti/dl
 
 go 1.24.3
 
-require github.com/shirou/gopsutil/v3 v3.24.5
+require (
+	github.com/shirou/gopsutil/v3 v3.24.5
+	golang.org/x/mod v0.24.0
+	golang.org/x/sys v0.20.0
+)
 
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
@@ -12,6 +16,4 @@ require (
 	github.com/tklauser/go-sysconf v0.3.12 // indirect
 	github.com/tklauser/numcpus v0.6.1 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
-	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
 )
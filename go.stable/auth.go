@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// hfHomeDir returns the huggingface_hub home directory: HF_HOME, else
+// $XDG_CACHE_HOME/huggingface, else ~/.cache/huggingface.
+func hfHomeDir() (string, error) {
+	if hfHome := os.Getenv("HF_HOME"); hfHome != "" {
+		return hfHome, nil
+	}
+	if xdgCache := os.Getenv("XDG_CACHE_HOME"); xdgCache != "" {
+		return filepath.Join(xdgCache, "huggingface"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine the Hugging Face home directory (set HF_HOME): %w", err)
+	}
+	return filepath.Join(home, ".cache", "huggingface"), nil
+}
+
+// hfTokenPath returns where huggingface-cli login stores the token: HF_TOKEN_PATH,
+// else <HF home>/token.
+func hfTokenPath() (string, error) {
+	if tokenPath := os.Getenv("HF_TOKEN_PATH"); tokenPath != "" {
+		return tokenPath, nil
+	}
+	hfHome, err := hfHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hfHome, "token"), nil
+}
+
+// discoverHFToken finds the Hugging Face token the way huggingface_hub does: the
+// HF_TOKEN environment variable (or the older HUGGING_FACE_HUB_TOKEN), else the token
+// file written by 'huggingface-cli login' or 'dl auth login'. source describes where
+// it came from; both are empty if there is no token.
+func discoverHFToken() (token string, source string) {
+	for _, env := range []string{"HF_TOKEN", "HUGGING_FACE_HUB_TOKEN"} {
+		if token = strings.TrimSpace(os.Getenv(env)); token != "" {
+			return token, env + " environment variable"
+		}
+	}
+	tokenPath, err := hfTokenPath()
+	if err != nil {
+		appLogger.Printf("[Auth] %v", err)
+		return "", ""
+	}
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			appLogger.Printf("[Auth] Could not read token file %s: %v", tokenPath, err)
+		}
+		return "", ""
+	}
+	if token = strings.TrimSpace(string(data)); token == "" {
+		return "", ""
+	}
+	return token, tokenPath
+}
+
+// forwardTokenOnRedirect carries the Authorization header of the original request
+// over to a redirect only while it stays on the Hub or the configured endpoint (see
+// isHFHost). Downloads are redirected to storage CDNs with signed URLs, which must
+// never see the token.
+func forwardTokenOnRedirect(req *http.Request, via []*http.Request) {
+	if auth := via[0].Header.Get("Authorization"); auth != "" && isHFHost(req.URL.Host) {
+		req.Header.Set("Authorization", auth)
+	} else if req.Header.Get("Authorization") != "" {
+		appLogger.Printf("[Auth] Not sending the token to %s after a redirect.", req.URL.Host)
+		req.Header.Del("Authorization")
+	}
+}
+
+// hfCheckRedirect is the CheckRedirect of clients that may send the token.
+func hfCheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	forwardTokenOnRedirect(req, via)
+	return nil
+}
+
+// hfWhoAmI is the part of the /api/whoami-v2 response shown by 'dl auth whoami'.
+type hfWhoAmI struct {
+	Type     string `json:"type"` // "user" or "org"
+	Name     string `json:"name"`
+	Fullname string `json:"fullname"`
+	Email    string `json:"email"`
+	Orgs     []struct {
+		Name string `json:"name"`
+	} `json:"orgs"`
+	Auth struct {
+		AccessToken struct {
+			DisplayName string `json:"displayName"`
+			Role        string `json:"role"` // read, write or fineGrained
+		} `json:"accessToken"`
+	} `json:"auth"`
+}
+
+// fetchWhoAmI validates token against the endpoint and returns its owner.
+func fetchWhoAmI(token string) (*hfWhoAmI, error) {
+	apiURL := hfEndpointURL("api/whoami-v2")
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := http.Client{Timeout: 30 * time.Second, CheckRedirect: hfCheckRedirect}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting %s: %w", apiURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("the token was rejected by %s (401): it is invalid, expired or revoked", hfEndpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("whoami request to %s failed with status %s. Detail: %s", apiURL, resp.Status, strings.TrimSpace(string(bodyBytes)))
+	}
+	var info hfWhoAmI
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("error decoding whoami response: %w", err)
+	}
+	return &info, nil
+}
+
+func printWhoAmI(info *hfWhoAmI) {
+	name := info.Name
+	if info.Fullname != "" && info.Fullname != info.Name {
+		name = fmt.Sprintf("%s (%s)", info.Name, info.Fullname)
+	}
+	fmt.Printf("Logged in as: %s\n", name)
+	if len(info.Orgs) > 0 {
+		var orgs []string
+		for _, org := range info.Orgs {
+			orgs = append(orgs, org.Name)
+		}
+		fmt.Printf("Orgs:         %s\n", strings.Join(orgs, ", "))
+	}
+	if token := info.Auth.AccessToken; token.DisplayName != "" || token.Role != "" {
+		fmt.Printf("Token:        %s (%s)\n", token.DisplayName, token.Role)
+	}
+}
+
+// HandleAuth implements 'dl auth login [--token <token>]', 'dl auth logout' and 'dl auth whoami'.
+func HandleAuth(args []string) bool {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: Missing subcommand for 'auth' (login, logout or whoami).")
+		return false
+	}
+	switch args[0] {
+	case "login":
+		return handleAuthLogin(args[1:])
+	case "logout":
+		return handleAuthLogout()
+	case "whoami":
+		token, source := discoverHFToken()
+		if token == "" {
+			fmt.Fprintln(os.Stderr, "Not logged in. Run 'dl auth login' or set HF_TOKEN.")
+			return false
+		}
+		info, err := fetchWhoAmI(token)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Token from %s: %v\n", source, err)
+			return false
+		}
+		printWhoAmI(info)
+		fmt.Printf("Token source: %s\n", source)
+		if hfEndpoint != defaultHFEndpoint {
+			fmt.Printf("Endpoint:     %s\n", hfEndpoint)
+		}
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "Error: Unknown subcommand 'auth %s' (expected login, logout or whoami).\n", args[0])
+	return false
+}
+
+// handleAuthLogin validates a token and stores it where huggingface_hub looks for it,
+// so huggingface-cli and other tools pick it up too. The token is read from stdin
+// (without echo on a terminal); --token takes it from the arguments instead, where
+// ps and the shell history can see it.
+func handleAuthLogin(args []string) bool {
+	loginFlags := flag.NewFlagSet("auth login", flag.ContinueOnError)
+	loginFlags.SetOutput(os.Stderr)
+	tokenArg := loginFlags.String("token", "", "The token; visible to other users in ps and kept in the shell history")
+	if err := loginFlags.Parse(args); err != nil {
+		return false
+	}
+	if loginFlags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Error: 'auth login' reads the token from stdin. Pipe it in (e.g. echo \"$HF_TOKEN\" | dl auth login) or, if you must, pass --token <token>.")
+		return false
+	}
+	var token string
+	if *tokenArg != "" {
+		fmt.Fprintln(os.Stderr, "[WARN] A token given with --token is visible in the process list and saved in your shell history. Prefer entering it at the prompt or piping it to stdin.")
+		token = strings.TrimSpace(*tokenArg)
+	} else if _, isTerminal := terminalWidth(os.Stdin); isTerminal {
+		fmt.Fprintf(os.Stderr, "Enter your Hugging Face token (from %s): ", hfEndpointURL("settings/tokens"))
+		secret, err := readSecret(os.Stdin)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not read the token: %v\n", err)
+			return false
+		}
+		token = strings.TrimSpace(secret)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not read the token from stdin: %v\n", err)
+			return false
+		}
+		token = strings.TrimSpace(line)
+	}
+	if token == "" {
+		fmt.Fprintln(os.Stderr, "Error: No token given.")
+		return false
+	}
+
+	info, err := fetchWhoAmI(token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	tokenPath, err := hfTokenPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(tokenPath), 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Could not create '%s': %v\n", filepath.Dir(tokenPath), err)
+		return false
+	}
+	if err := os.WriteFile(tokenPath, []byte(token), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Could not save the token to '%s': %v\n", tokenPath, err)
+		return false
+	}
+	appLogger.Printf("[Auth] Token for %s saved to %s.", info.Name, tokenPath)
+	printWhoAmI(info)
+	fmt.Printf("Token saved to %s\n", tokenPath)
+	if os.Getenv("HF_TOKEN") != "" {
+		fmt.Fprintln(os.Stderr, "[WARN] HF_TOKEN is set in the environment and takes precedence over the saved token.")
+	}
+	return true
+}
+
+func handleAuthLogout() bool {
+	tokenPath, err := hfTokenPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	if err := os.Remove(tokenPath); err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not remove '%s': %v\n", tokenPath, err)
+			return false
+		}
+		fmt.Printf("No saved token at %s\n", tokenPath)
+	} else {
+		appLogger.Printf("[Auth] Removed token file %s.", tokenPath)
+		fmt.Printf("Removed token %s\n", tokenPath)
+	}
+	for _, env := range []string{"HF_TOKEN", "HUGGING_FACE_HUB_TOKEN"} {
+		if os.Getenv(env) != "" {
+			fmt.Fprintf(os.Stderr, "[WARN] %s is still set in the environment and will be used.\n", env)
+		}
+	}
+	return true
+}
+
+// readSecretLine reads up to a newline byte by byte, so nothing typed after it is
+// buffered away from later reads of f.
+func readSecretLine(f *os.File) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			if buf[0] != '\r' {
+				line = append(line, buf[0])
+			}
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return string(line), nil
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -21,6 +25,10 @@ import (
 const (
 	maxFilenameDisplayLength = 30 // Increased for potentially longer paths
 	progressBarWidth         = 25
+	minFilenameDisplayLength = 12 // Narrow terminals shrink the name and bar down to these
+	minProgressBarWidth      = 10
+	progressLineReserve      = 70 // Columns for percentage, sizes, speed and ETA after the bar
+	defaultTerminalWidth     = 80 // Used when the width cannot be detected
 	redrawInterval           = 150 * time.Millisecond
 	speedUpdateInterval      = 750 * time.Millisecond
 )
@@ -63,6 +71,13 @@ func formatSpeed(bytesPerSecond float64) string {
 	return fmt.Sprintf("%6.2f MB/s", mbps)
 }
 
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func maxInt(a, b int) int {
 	if a > b {
 		return a
@@ -202,6 +217,17 @@ type ProgressWriter struct {
 	Current              int64
 	IsFinished           bool
 	ErrorMsg             string
+	HTTPStatus           int    // Status of the response that made the download fail, 0 otherwise
+	ExpectedSHA256       string // If set, the downloaded content is hashed and checked against it
+	SHA256               string // Hash of the downloaded content if it was computed during the download
+	BlobID               string // Git blob id of Hugging Face files, recorded in the manifest
+	Replace              bool   // The file under the final name is outdated and is only replaced once the new one is complete
+	Retry                int    // Current retry number, 0 during the first attempt
+	MaxRetries           int
+	RetryReason          string    // Error of the attempt that caused the current retry
+	Started              bool      // Set once downloadFile picks the file up, queued until then
+	ETag                 string    // ETag of the response the file was downloaded with
+	CompletedAt          time.Time // When the file was completed, zero if it was already on disk
 	mu                   sync.Mutex
 	manager              *ProgressManager
 	lastSpeedCalcTime    time.Time
@@ -209,22 +235,23 @@ type ProgressWriter struct {
 	currentSpeedBps      float64
 }
 
-func newProgressWriter(id int, url, actualFileName string, totalSize int64, manager *ProgressManager) *ProgressWriter {
-	// For display, use the base name of actualFileName, then shorten if necessary
+// shortenDisplayName shortens the base name of actualFileName to at most maxLen
+// characters for display.
+func shortenDisplayName(actualFileName string, maxLen int) string {
 	displayFileName := filepath.Base(actualFileName) // Get "file.txt" from "subdir/file.txt"
-	if len(displayFileName) > maxFilenameDisplayLength {
+	if len(displayFileName) > maxLen {
 		// Simple truncation: "...verylongfilename.ext" -> "...ename.ext"
 		// Or if no ext, "...verylongfilename" -> "...filename"
 		ext := filepath.Ext(displayFileName)
 		nameWithoutExt := strings.TrimSuffix(displayFileName, ext)
 
 		// Available length for name (excluding ext and "...")
-		availableNameLen := maxFilenameDisplayLength - len(ext) - 3
+		availableNameLen := maxLen - len(ext) - 3
 		if availableNameLen < 1 { // Not enough space for even "..." + one char + ext
-			if maxFilenameDisplayLength > 3 { // Can fit "..."
-				displayFileName = displayFileName[:maxFilenameDisplayLength-3] + "..."
+			if maxLen > 3 { // Can fit "..."
+				displayFileName = displayFileName[:maxLen-3] + "..."
 			} else { // Can't even fit "...", just truncate
-				displayFileName = displayFileName[:maxFilenameDisplayLength]
+				displayFileName = displayFileName[:maxLen]
 			}
 		} else {
 			// Truncate nameWithoutExt if it's too long
@@ -234,9 +261,12 @@ func newProgressWriter(id int, url, actualFileName string, totalSize int64, mana
 			displayFileName = "..." + nameWithoutExt + ext
 		}
 	}
+	return displayFileName
+}
 
+func newProgressWriter(id int, url, actualFileName string, totalSize int64, manager *ProgressManager) *ProgressWriter {
 	return &ProgressWriter{
-		id: id, URL: url, FileName: displayFileName, ActualFileName: actualFileName, Total: totalSize,
+		id: id, URL: url, FileName: shortenDisplayName(actualFileName, maxFilenameDisplayLength), ActualFileName: actualFileName, Total: totalSize,
 		manager: manager, lastSpeedCalcTime: time.Now(),
 	}
 }
@@ -256,6 +286,29 @@ func (pw *ProgressWriter) Write(p []byte) (int, error) {
 	return n, nil
 }
 
+// markStarted records that the download left the queue.
+func (pw *ProgressWriter) markStarted() {
+	pw.mu.Lock()
+	pw.Started = true
+	pw.mu.Unlock()
+	if pw.manager != nil {
+		pw.manager.requestRedraw()
+	}
+}
+
+// setRetry records which retry is in progress, and why, so the bar can show it.
+func (pw *ProgressWriter) setRetry(retry int, maxRetries int, reason string) {
+	pw.mu.Lock()
+	pw.Retry, pw.MaxRetries, pw.RetryReason = retry, maxRetries, reason
+	if retry > 0 {
+		pw.currentSpeedBps = 0 // Speed of the failed attempt is meaningless now
+	}
+	pw.mu.Unlock()
+	if pw.manager != nil {
+		pw.manager.requestRedraw()
+	}
+}
+
 func (pw *ProgressWriter) UpdateSpeed() {
 	pw.mu.Lock()
 	defer pw.mu.Unlock()
@@ -296,11 +349,16 @@ func (pw *ProgressWriter) MarkFinished(errMsg string) {
 	}
 }
 
-func (pw *ProgressWriter) getProgressString() string {
+func (pw *ProgressWriter) getProgressString(layout progressLayout) string {
 	pw.mu.Lock()
 	defer pw.mu.Unlock()
 	current, total, isFinished, errorMsg := pw.Current, pw.Total, pw.IsFinished, pw.ErrorMsg
 	fileName, currentSpeed := pw.FileName, pw.currentSpeedBps // pw.FileName is already shortened base name
+	nameWidth, barWidth := layout.nameWidth, layout.barWidth
+	if nameWidth != maxFilenameDisplayLength {
+		fileName = shortenDisplayName(pw.ActualFileName, nameWidth)
+	}
+	retry, maxRetries := pw.Retry, pw.MaxRetries
 	speedStr, etaStr := formatSpeed(currentSpeed), "N/A"
 
 	if isFinished {
@@ -325,7 +383,7 @@ func (pw *ProgressWriter) getProgressString() string {
 	// Constructing the progress bar string
 	if isFinished {
 		if errorMsg != "" {
-			maxErrDisplay := progressBarWidth + 20 // Allow more space for error message
+			maxErrDisplay := barWidth + 20 // Allow more space for error message
 			displayError := errorMsg
 			runes := []rune(displayError)
 			if len(runes) > maxErrDisplay {
@@ -335,12 +393,12 @@ func (pw *ProgressWriter) getProgressString() string {
 					displayError = string(runes[:maxErrDisplay-3]) + "..."
 				}
 			}
-			return fmt.Sprintf("%-*s: [ERROR: %s]", maxFilenameDisplayLength, fileName, displayError)
+			return fmt.Sprintf("%-*s: [ERROR: %s]", nameWidth, fileName, displayError)
 		}
 		// Finished successfully
-		percentage, bar := 100.0, strings.Repeat("=", progressBarWidth)
+		percentage, bar := 100.0, strings.Repeat("=", barWidth)
 		currentMB := float64(current) / (1024 * 1024)
-		return fmt.Sprintf("%-*s: [%s] %6.2f%% (%6.2f MB) @ %s", maxFilenameDisplayLength, fileName, bar, percentage, currentMB, speedStr)
+		return fmt.Sprintf("%-*s: [%s] %6.2f%% (%6.2f MB) @ %s", nameWidth, fileName, bar, percentage, currentMB, speedStr)
 	}
 
 	// Not finished, draw progress bar
@@ -353,24 +411,24 @@ func (pw *ProgressWriter) getProgressString() string {
 		if percentage < 0 {
 			percentage = 0
 		} // Floor at 0%
-		filledWidth := int(math.Round(float64(progressBarWidth) * percentage / 100.0))
-		if filledWidth > progressBarWidth {
-			filledWidth = progressBarWidth
+		filledWidth := int(math.Round(float64(barWidth) * percentage / 100.0))
+		if filledWidth > barWidth {
+			filledWidth = barWidth
 		}
 		if filledWidth < 0 {
 			filledWidth = 0
 		}
 
-		barRunes := []rune(strings.Repeat(" ", progressBarWidth))
+		barRunes := []rune(strings.Repeat(" ", barWidth))
 		for i := 0; i < filledWidth; i++ {
-			if i < progressBarWidth {
+			if i < barWidth {
 				barRunes[i] = '='
 			}
 		}
 		// Add '>' for active downloads unless it's full
-		if filledWidth < progressBarWidth && percentage < 100.0 && percentage >= 0.0 {
+		if filledWidth < barWidth && percentage < 100.0 && percentage >= 0.0 {
 			idxForArrow := filledWidth // Place arrow at the end of filled part
-			if idxForArrow >= 0 && idxForArrow < progressBarWidth {
+			if idxForArrow >= 0 && idxForArrow < barWidth {
 				barRunes[idxForArrow] = '>'
 			}
 		}
@@ -381,17 +439,17 @@ func (pw *ProgressWriter) getProgressString() string {
 			spinChars := []string{"|", "/", "-", "\\"}
 			// Use time to cycle spinner, ensuring it changes with redraws
 			spinner := spinChars[int(time.Now().UnixNano()/(int64(redrawInterval)/int64(len(spinChars))))%len(spinChars)]
-			mid := progressBarWidth / 2
-			barRunes := []rune(strings.Repeat(" ", progressBarWidth))
+			mid := barWidth / 2
+			barRunes := []rune(strings.Repeat(" ", barWidth))
 			idxSpinner := maxInt(0, mid-1) // Ensure index is valid
-			if progressBarWidth > 0 && idxSpinner < progressBarWidth {
+			if barWidth > 0 && idxSpinner < barWidth {
 				barRunes[idxSpinner] = []rune(spinner)[0]
-			} else if progressBarWidth > 0 { // Fallback for very small progress bar
+			} else if barWidth > 0 { // Fallback for very small progress bar
 				barRunes[0] = []rune(spinner)[0]
 			}
 			barFill = string(barRunes)
 		} else { // Total unknown, nothing downloaded yet
-			barFill = strings.Repeat("?", progressBarWidth)
+			barFill = strings.Repeat("?", barWidth)
 		}
 	}
 	bar := "[" + barFill + "]"
@@ -400,11 +458,14 @@ func (pw *ProgressWriter) getProgressString() string {
 	if total > 0 {
 		totalMBStr = fmt.Sprintf("%.2f MB", float64(total)/(1024*1024))
 	}
+	if retry > 0 {
+		etaStr += fmt.Sprintf(" [retry %d/%d]", retry, maxRetries)
+	}
 
 	if indeterminate {
-		return fmt.Sprintf("%-*s: %s (%6.2f MB / unknown) @ %s ETA: %s", maxFilenameDisplayLength, fileName, bar, currentMB, speedStr, etaStr)
+		return fmt.Sprintf("%-*s: %s (%6.2f MB / unknown) @ %s ETA: %s", nameWidth, fileName, bar, currentMB, speedStr, etaStr)
 	}
-	return fmt.Sprintf("%-*s: %s %6.2f%% (%6.2f MB / %s) @ %s ETA: %s", maxFilenameDisplayLength, fileName, bar, percentage, currentMB, totalMBStr, speedStr, etaStr)
+	return fmt.Sprintf("%-*s: %s %6.2f%% (%6.2f MB / %s) @ %s ETA: %s", nameWidth, fileName, bar, percentage, currentMB, totalMBStr, speedStr, etaStr)
 }
 
 // --- ProgressManager ---
@@ -413,14 +474,18 @@ type ProgressManager struct {
 	mu                 sync.Mutex
 	redrawPending      bool
 	stopRedraw         chan struct{}
+	stopOnce           sync.Once // Stop may be called early and again by a deferred call
+	settled            bool      // Set by Stop, speeds are no longer updated
 	wg                 sync.WaitGroup
 	displayConcurrency int
+	renderer           progressRenderer
 }
 
 func NewProgressManager(displayConcurrency int) *ProgressManager {
 	m := &ProgressManager{
 		bars: make([]*ProgressWriter, 0), stopRedraw: make(chan struct{}),
 		displayConcurrency: displayConcurrency,
+		renderer:           newProgressRenderer(progressMode),
 	}
 	m.wg.Add(1)
 	go m.redrawLoop()
@@ -429,39 +494,61 @@ func NewProgressManager(displayConcurrency int) *ProgressManager {
 
 func (m *ProgressManager) AddInitialDownloads(pws []*ProgressWriter) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	m.bars = append(m.bars, pws...)
 	appLogger.Printf("[PM.AddInitialDownloads] Added %d initial bars. Total bars: %d.", len(pws), len(m.bars))
-	m.redrawPending = true
+	m.mu.Unlock()
+	m.performActualDraw(false) // Draw right away so the queue is reported before any transfer starts
+}
+
+// settleBars freezes the bars for the final draw, so every renderer reports the
+// same final state: downloads still running are cut short by the shutdown and stay
+// unfinished, with no stale speed.
+func (m *ProgressManager) settleBars() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settled = true
+	for _, bar := range m.bars {
+		bar.mu.Lock()
+		if !bar.IsFinished {
+			bar.currentSpeedBps = 0
+		}
+		bar.mu.Unlock()
+	}
 }
 
 func (m *ProgressManager) requestRedraw() { m.mu.Lock(); m.redrawPending = true; m.mu.Unlock() }
 
+// currentSpeed sums the speed of all unfinished downloads.
+func (m *ProgressManager) currentSpeed() float64 {
+	m.mu.Lock()
+	barsSnapshot := make([]*ProgressWriter, len(m.bars))
+	copy(barsSnapshot, m.bars)
+	m.mu.Unlock()
+	var speed float64
+	for _, bar := range barsSnapshot {
+		bar.mu.Lock()
+		if !bar.IsFinished {
+			speed += bar.currentSpeedBps
+		}
+		bar.mu.Unlock()
+	}
+	return speed
+}
+
 func (m *ProgressManager) redrawLoop() {
 	defer m.wg.Done()
 	ticker := time.NewTicker(redrawInterval)
 	defer ticker.Stop()
 
-	stdoutMutex.Lock()
-	fmt.Print("\033[?25l") // Hide cursor
-	stdoutMutex.Unlock()
+	m.renderer.begin()
 
 	defer func() {
 		m.performActualDraw(true) // Final draw to show all completed/errored
-		stdoutMutex.Lock()
-		fmt.Print("\033[?25h") // Show cursor
-		// Ensure prompt is on a new line after final output and cursor restoration.
-		// Only add a newline if there was some output (i.e., if bars existed at some point).
-		// This check is a bit indirect; ideally, we'd know if `performActualDraw` actually drew something.
-		// For now, assume if manager was active, a newline is good.
 		m.mu.Lock()
-		needsNewline := len(m.bars) > 0 // Heuristic: if bars ever existed, manager was active.
+		hadBars := len(m.bars) > 0 // Heuristic: if bars ever existed, manager was active.
 		m.mu.Unlock()
-		if needsNewline {
-			fmt.Println()
-		}
-		stdoutMutex.Unlock()
-		appLogger.Println("[PM.redrawLoop] Cursor restored, final draw performed.")
+		m.renderer.end(hadBars)
+		appLogger.Println("[PM.redrawLoop] Renderer finished, final draw performed.")
 	}()
 
 	for {
@@ -477,7 +564,7 @@ func (m *ProgressManager) redrawLoop() {
 		m.mu.Lock()
 		// Update speed for active, non-finished bars
 		for _, bar := range m.bars {
-			if !bar.IsFinished && (bar.Current > 0 || bar.Total > 0) {
+			if !m.settled && !bar.IsFinished && (bar.Current > 0 || bar.Total > 0) {
 				bar.UpdateSpeed()
 			}
 		}
@@ -510,7 +597,7 @@ func (m *ProgressManager) hasIndeterminateOrActiveBarsLocked() bool {
 	return false
 }
 
-func (m *ProgressManager) getOverallProgressString(barsSnapshot []*ProgressWriter) string {
+func (m *ProgressManager) getOverallProgressString(barsSnapshot []*ProgressWriter, layout progressLayout) string {
 	var currentBytes, expectedBytes int64
 	var overallSpeed float64
 	allDone := true
@@ -604,7 +691,7 @@ func (m *ProgressManager) getOverallProgressString(barsSnapshot []*ProgressWrite
 		etaStr = "---"
 	}
 
-	barW := progressBarWidth + 10
+	barW := layout.barWidth + 10
 	filledW := 0
 	if (expectedBytes > 0 || (allDone && totalTasks > 0)) && percentage >= 0 {
 		filledW = int(math.Round(float64(barW) * percentage / 100.0))
@@ -625,6 +712,9 @@ func (m *ProgressManager) getOverallProgressString(barsSnapshot []*ProgressWrite
 	if len(speedStr) < 10 {
 		speedStr = fmt.Sprintf("%-10s", speedStr)
 	}
+	if capStr := rateLimitSummary(); capStr != "" && !allDone {
+		speedStr += " (" + capStr + ")"
+	}
 
 	return fmt.Sprintf("Overall %-*s %6.2f%% (%s / %s) @ %s ETA: %s\n%s",
 		barW+1, overallBar, percentage, currentStr, expectedStr, speedStr, etaStr, filesInfo)
@@ -645,32 +735,82 @@ func (m *ProgressManager) performActualDraw(isFinalDraw bool) {
 		return // Do nothing, preserve current terminal output
 	}
 
-	if isFinalDraw {
-		for _, b := range barsSnapshot {
-			b.mu.Lock()
-			if !b.IsFinished {
-				b.IsFinished = true
-				b.currentSpeedBps = 0
-				if b.ErrorMsg == "" {
-					if b.Total > 0 && b.Current < b.Total {
-						b.Current = b.Total
-					} else if b.Total <= 0 && b.Current > 0 {
-						b.Total = b.Current
-					}
-				}
-			}
-			b.mu.Unlock()
+	m.renderer.draw(m, barsSnapshot, isFinalDraw)
+}
+
+// progressLayout holds the column widths used for one redraw.
+type progressLayout struct {
+	nameWidth, barWidth int
+	lineWidth           int // Lines are cut to this many columns so they never wrap
+}
+
+// layoutForWidth fits the file name and bar into a terminal of cols columns,
+// shrinking the bar first and the name second.
+func layoutForWidth(cols int) progressLayout {
+	if cols <= 0 {
+		cols = defaultTerminalWidth
+	}
+	layout := progressLayout{nameWidth: maxFilenameDisplayLength, barWidth: progressBarWidth, lineWidth: cols - 1}
+	space := cols - 1 - progressLineReserve - 4 // ": [" and "]"
+	if space >= layout.nameWidth+layout.barWidth {
+		return layout
+	}
+	layout.barWidth = maxInt(minProgressBarWidth, space-layout.nameWidth)
+	layout.nameWidth = maxInt(minFilenameDisplayLength, space-layout.barWidth)
+	if layout.nameWidth > maxFilenameDisplayLength {
+		layout.nameWidth = maxFilenameDisplayLength
+	}
+	return layout
+}
+
+// fitToWidth cuts every line of s to at most width runes.
+func fitToWidth(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) > width {
+			lines[i] = string(runes[:width])
 		}
 	}
+	return strings.Join(lines, "\n")
+}
+
+// terminalRenderer is the interactive ANSI display: it clears the screen and redraws
+// the bars of the active downloads in place, sized to the current terminal width.
+// It draws to stderr like every other progress display, so stdout stays clean.
+type terminalRenderer struct{}
+
+func (terminalRenderer) begin() {
+	stdoutMutex.Lock()
+	fmt.Fprint(os.Stderr, "\033[?25l") // Hide cursor
+	stdoutMutex.Unlock()
+}
+
+func (terminalRenderer) end(hadBars bool) {
+	stdoutMutex.Lock()
+	fmt.Fprint(os.Stderr, "\033[?25h") // Show cursor
+	// Ensure prompt is on a new line after final output and cursor restoration.
+	if hadBars {
+		fmt.Fprintln(os.Stderr)
+	}
+	stdoutMutex.Unlock()
+}
+
+func (terminalRenderer) draw(m *ProgressManager, barsSnapshot []*ProgressWriter, isFinalDraw bool) {
+	cols, _ := terminalWidth(os.Stderr) // Re-read every draw so resizing the window takes effect
+	layout := layoutForWidth(cols)
+	separator := strings.Repeat("-", minInt(80, layout.lineWidth))
 
 	stdoutMutex.Lock()
 	defer stdoutMutex.Unlock()
 
 	// Clear screen and print headers ONLY if we are actually drawing something or it's a final cleanup.
-	fmt.Print("\033[H\033[2J")
+	fmt.Fprint(os.Stderr, "\033[H\033[2J")
 	if len(barsSnapshot) > 0 || isFinalDraw { // Only print header if there's content or it's the end
-		fmt.Println("Download Progress:")
-		fmt.Println(strings.Repeat("-", 80))
+		fmt.Fprintln(os.Stderr, "Download Progress:")
+		fmt.Fprintln(os.Stderr, separator)
 	}
 
 	barsToDisplay := make([]*ProgressWriter, 0)
@@ -730,31 +870,70 @@ func (m *ProgressManager) performActualDraw(isFinalDraw bool) {
 	}
 
 	for _, bar := range barsToDisplay {
-		fmt.Println(bar.getProgressString())
+		fmt.Fprintln(os.Stderr, fitToWidth(bar.getProgressString(layout), layout.lineWidth))
 	}
 
 	if !isFinalDraw && len(barsSnapshot) > len(barsToDisplay) {
 		remainingCount := len(barsSnapshot) - len(barsToDisplay)
 		if remainingCount > 0 {
-			fmt.Printf("... and %d more downloads ...\n", remainingCount)
+			fmt.Fprintf(os.Stderr, "... and %d more downloads ...\n", remainingCount)
 		}
 	}
 
 	if len(barsSnapshot) > 0 || isFinalDraw { // Corresponding condition for footer
-		fmt.Println(strings.Repeat("-", 80))
-		fmt.Println(m.getOverallProgressString(barsSnapshot)) // getOverallProgressString handles len(barsSnapshot) == 0
+		fmt.Fprintln(os.Stderr, separator)
+		fmt.Fprintln(os.Stderr, fitToWidth(m.getOverallProgressString(barsSnapshot, layout), layout.lineWidth)) // getOverallProgressString handles len(barsSnapshot) == 0
 	}
-	os.Stdout.Sync()
+	os.Stderr.Sync()
 }
 
 func (m *ProgressManager) Stop() {
 	appLogger.Println("[PM.Stop] Stop method called.")
-	close(m.stopRedraw)
+	m.stopOnce.Do(func() {
+		m.settleBars()
+		close(m.stopRedraw)
+	})
 	appLogger.Println("[PM.Stop] Waiting for redrawLoop to finish.")
 	m.wg.Wait()
 	appLogger.Println("[PM.Stop] RedrawLoop finished.")
 }
 
+// --- HTTP Helpers ---
+
+// newDownloadClient returns the HTTP client used for file transfers.
+func newDownloadClient(logPrefix string) *http.Client {
+	return &http.Client{
+		Timeout: 60 * time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 { // Stop after 10 redirects to prevent loops
+				return http.ErrUseLastResponse
+			}
+			// Forward the Range header on redirect, as the default client may not. The
+			// token only goes along while the redirect stays on a Hugging Face host.
+			forwardTokenOnRedirect(req, via)
+			if originalRange := via[0].Header.Get("Range"); originalRange != "" {
+				req.Header.Set("Range", originalRange)
+			}
+			appLogger.Printf("%s Following redirect to %s, ensuring headers are preserved.", logPrefix, req.URL)
+			return nil
+		},
+	}
+}
+
+// newDownloadRequest builds a GET request for fileURL with the common headers set,
+// canceled with downloadCtx when dl shuts down.
+func newDownloadRequest(fileURL string, hfToken string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(downloadCtx, "GET", fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Go-File-Downloader/1.1")
+	if hfToken != "" && isHFURL(fileURL) {
+		req.Header.Set("Authorization", "Bearer "+hfToken)
+	}
+	return req, nil
+}
+
 // --- Downloader Function ---
 func downloadFile(pw *ProgressWriter, wg *sync.WaitGroup, downloadDir string, manager *ProgressManager, hfToken string) {
 	logPrefix := fmt.Sprintf("[downloadFile:%s]", pw.URL)
@@ -763,8 +942,10 @@ func downloadFile(pw *ProgressWriter, wg *sync.WaitGroup, downloadDir string, ma
 		appLogger.Printf("%s Goroutine finished (File: %s, Error: '%s').", logPrefix, pw.ActualFileName, pw.ErrorMsg)
 		wg.Done()
 	}()
+	pw.markStarted()
 
 	filePath := filepath.Join(downloadDir, pw.ActualFileName)
+	partPath := partFilePath(filePath)
 	fileDir := filepath.Dir(filePath)
 
 	err := os.MkdirAll(fileDir, os.ModePerm)
@@ -773,75 +954,176 @@ func downloadFile(pw *ProgressWriter, wg *sync.WaitGroup, downloadDir string, ma
 		return
 	}
 
-	var currentSize int64
+	pw.mu.Lock()
+	totalSize := pw.Total
+	pw.mu.Unlock()
+
+	// Bytes are written to <name>.part and only renamed once complete, so a file
+	// under its final name is a finished download.
 	fileInfo, err := os.Stat(filePath)
-	if err == nil {
-		currentSize = fileInfo.Size()
+	if err == nil && pw.Replace {
+		// Same-size changes are common (e.g. a fixed config value), so the size says
+		// nothing here. The old file stays usable until the new one is verified.
+		appLogger.Printf("%s '%s' is outdated; downloading to '%s' to replace it.", logPrefix, filePath, partPath)
+	} else if err == nil {
+		if totalSize <= 0 || fileInfo.Size() == totalSize {
+			appLogger.Printf("%s File '%s' is already complete (size %d, expected %d).", logPrefix, filePath, fileInfo.Size(), totalSize)
+			pw.mu.Lock()
+			pw.Current = fileInfo.Size()
+			pw.mu.Unlock()
+			pw.MarkFinished("") // Mark as success
+			return
+		}
+		if _, partErr := os.Stat(partPath); fileInfo.Size() < totalSize && os.IsNotExist(partErr) {
+			// Left behind by an older version that wrote to the final name directly; continue it as a .part file.
+			appLogger.Printf("%s Moving partial file '%s' (%d of %d bytes) to '%s' to resume it.", logPrefix, filePath, fileInfo.Size(), totalSize, partPath)
+			if renameErr := os.Rename(filePath, partPath); renameErr != nil {
+				pw.MarkFinished(fmt.Sprintf("Rename: %v", shortenError(renameErr, 25)))
+				return
+			}
+		} else {
+			appLogger.Printf("%s Existing '%s' has size %d but remote has %d. Downloading again.", logPrefix, filePath, fileInfo.Size(), totalSize)
+		}
 	} else if !os.IsNotExist(err) {
 		pw.MarkFinished(fmt.Sprintf("Stat file '%s': %v", filePath, shortenError(err, 20)))
 		return
 	}
 
+	var partSize int64
+	if partInfo, statErr := os.Stat(partPath); statErr == nil {
+		partSize = partInfo.Size()
+	}
+	record := loadResumeRecord(partPath)
+	if record != nil && (record.URL != pw.URL || (totalSize > 0 && record.Size > 0 && record.Size != totalSize)) {
+		appLogger.Printf("%s Resume record for '%s' is for %s (size %d), now %s (size %d). Starting over.", logPrefix, partPath, record.URL, record.Size, pw.URL, totalSize)
+		discardPartFile(partPath, record)
+		record, partSize = nil, 0
+	}
+	if record == nil {
+		record = newResumeRecord(partPath, pw.URL, totalSize)
+	}
 	pw.mu.Lock()
-	pw.Current = currentSize // Set current progress
-	totalSize := pw.Total
+	pw.Current = partSize // Set current progress
 	pw.mu.Unlock()
 
-	// Check if file is already complete
-	if totalSize > 0 && currentSize >= totalSize {
-		appLogger.Printf("%s File '%s' is already complete (size %d >= total %d).", logPrefix, filePath, currentSize, totalSize)
-		if currentSize > totalSize {
-			appLogger.Printf("%s WARNING: Existing file size (%d) is larger than expected (%d). Truncating.", logPrefix, currentSize, totalSize)
-			if truncErr := os.Truncate(filePath, totalSize); truncErr != nil {
-				pw.MarkFinished(fmt.Sprintf("Truncate failed: %v", truncErr))
-				return
+	// A segmented .part file is preallocated, so its size says nothing about progress;
+	// the segments in the record do.
+	trySegmented := len(record.Segments) > 0 || (downloadSegments > 1 && partSize == 0 && totalSize >= minSegmentedFileSize)
+	client := newDownloadClient(logPrefix)
+	policy := downloadRetryPolicy
+
+	pw.setRetry(0, policy.MaxRetries, "")
+	for retry := 0; ; retry++ {
+
+		var attemptErr error
+		handled := false
+		if trySegmented {
+			handled, attemptErr = downloadFileSegmented(pw, partPath, record, hfToken, logPrefix)
+			if !handled {
+				appLogger.Printf("%s Segmented download not possible, using a single connection.", logPrefix)
+				trySegmented = false
 			}
+		}
+		if !handled {
+			attemptErr = downloadStream(pw, client, partPath, record, hfToken, logPrefix)
+		}
+		if attemptErr == nil {
+			attemptErr = finalizePartFile(pw, partPath, filePath, record)
+		}
+
+		if attemptErr == nil {
+			pw.MarkFinished("") // Success
+			break
+		}
+		if errors.Is(attemptErr, context.Canceled) {
+			appLogger.Printf("%s Copy interrupted by cancellation. Not marking as error.", logPrefix)
+			break
+		}
+		var statusErr *statusError
+		if errors.As(attemptErr, &statusErr) {
 			pw.mu.Lock()
-			pw.Current = totalSize
+			pw.HTTPStatus = statusErr.StatusCode
 			pw.mu.Unlock()
 		}
-		pw.MarkFinished("") // Mark as success
-		return
+		var retryErr *retryableError
+		if !errors.As(attemptErr, &retryErr) {
+			pw.MarkFinished(attemptErr.Error())
+			break
+		}
+		if retry >= policy.MaxRetries {
+			appLogger.Printf("%s Giving up after %d retries: %v", logPrefix, retry, attemptErr)
+			if policy.MaxRetries > 0 {
+				pw.MarkFinished(fmt.Sprintf("%s (after %d retries)", attemptErr.Error(), retry))
+			} else {
+				pw.MarkFinished(attemptErr.Error())
+			}
+			break
+		}
+		delay := policy.delay(retry+1, retryErr.retryAfter)
+		appLogger.Printf("%s Attempt %d failed (%v). Retrying in %s.", logPrefix, retry+1, attemptErr, delay)
+		pw.setRetry(retry+1, policy.MaxRetries, attemptErr.Error())
+		if !waitToRetry(delay) {
+			appLogger.Printf("%s Retry wait interrupted by cancellation. Not marking as error.", logPrefix)
+			break
+		}
 	}
+	appLogger.Printf("%s File copy process completed for '%s'. Final status IsFinished: %t, ErrorMsg: '%s'", logPrefix, filePath, pw.IsFinished, pw.ErrorMsg)
+}
 
-	client := http.Client{
-		Timeout: 60 * time.Minute,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 { // Stop after 10 redirects to prevent loops
-				return http.ErrUseLastResponse
-			}
-			// Forward Authorization and Range headers on redirect, as the default client may not.
-			if originalAuth := via[0].Header.Get("Authorization"); originalAuth != "" {
-				req.Header.Set("Authorization", originalAuth)
+// downloadStream performs one single-connection attempt into the .part file at
+// filePath, resuming from the bytes already on disk if the resume record's
+// validator still matches. Failures worth repeating are returned as *retryableError.
+func downloadStream(pw *ProgressWriter, client *http.Client, filePath string, record *resumeRecord, hfToken string, logPrefix string) error {
+	var currentSize int64
+	if fileInfo, err := os.Stat(filePath); err == nil {
+		currentSize = fileInfo.Size()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Stat file '%s': %v", filePath, shortenError(err, 20))
+	}
+
+	pw.mu.Lock()
+	pw.Current = currentSize
+	totalSize := pw.Total
+	pw.mu.Unlock()
+	if totalSize > 0 && currentSize == totalSize {
+		// A previous attempt got every byte before failing; only the checksum is left to check.
+		if pw.ExpectedSHA256 != "" {
+			actual, err := hashFileSHA256(filePath, -1)
+			if err != nil {
+				return fmt.Errorf("Hash: %v", shortenError(err, 25))
 			}
-			if originalRange := via[0].Header.Get("Range"); originalRange != "" {
-				req.Header.Set("Range", originalRange)
+			if msg := sha256MismatchMsg(pw.ExpectedSHA256, actual); msg != "" {
+				discardPartFile(filePath, record)
+				return errors.New(msg)
 			}
-			appLogger.Printf("%s Following redirect to %s, ensuring headers are preserved.", logPrefix, req.URL)
-			return nil
-		},
+			pw.mu.Lock()
+			pw.SHA256 = actual
+			pw.mu.Unlock()
+		}
+		return nil
 	}
-	req, err := http.NewRequest("GET", pw.URL, nil)
+
+	req, err := newDownloadRequest(pw.URL, hfToken)
 	if err != nil {
-		pw.MarkFinished(fmt.Sprintf("Req create: %v", shortenError(err, 25)))
-		return
+		return fmt.Errorf("Req create: %v", shortenError(err, 25))
 	}
-	req.Header.Set("User-Agent", "Go-File-Downloader/1.1")
 
 	if currentSize > 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", currentSize))
-		appLogger.Printf("%s Setting Range header for resume: %s", logPrefix, req.Header.Get("Range"))
-	}
-
-	if hfToken != "" && strings.Contains(pw.URL, "huggingface.co") {
-		req.Header.Set("Authorization", "Bearer "+hfToken)
-		appLogger.Printf("%s Using Hugging Face token for download request.", logPrefix)
+		// With If-Range the server sends the whole file (200) instead of a range if it
+		// changed since the partial bytes were fetched, so stale bytes are never spliced.
+		if validator := record.ifRange(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+		appLogger.Printf("%s Setting Range header for resume: %s (If-Range: %s)", logPrefix, req.Header.Get("Range"), req.Header.Get("If-Range"))
 	}
 
 	resp, getErr := client.Do(req)
 	if getErr != nil {
-		pw.MarkFinished(fmt.Sprintf("GET: %v", shortenError(getErr, 25)))
-		return
+		if ctxErr := downloadCtx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return &retryableError{err: fmt.Errorf("GET: %v", shortenError(getErr, 25))}
 	}
 	defer resp.Body.Close()
 
@@ -859,22 +1141,10 @@ func downloadFile(pw *ProgressWriter, wg *sync.WaitGroup, downloadDir string, ma
 			pw.mu.Unlock()
 		}
 	} else {
-		errorBodySnippet := ""
-		if resp.ContentLength > 0 && resp.ContentLength < 1024 {
-			bodyBytes, readErr := io.ReadAll(resp.Body)
-			if readErr == nil {
-				errorBodySnippet = strings.TrimSpace(string(bodyBytes))
-				if len(errorBodySnippet) > 100 {
-					errorBodySnippet = errorBodySnippet[:100] + "..."
-				}
-			}
-		}
-		if errorBodySnippet != "" {
-			pw.MarkFinished(fmt.Sprintf("HTTP %s (%s)", resp.Status, errorBodySnippet))
-		} else {
-			pw.MarkFinished(fmt.Sprintf("HTTP %s", resp.Status))
-		}
-		return
+		return httpStatusError(resp)
+	}
+	if !isResume || (record.ETag == "" && record.LastModified == "") {
+		record.setValidators(resp)
 	}
 
 	pw.mu.Lock()
@@ -892,10 +1162,17 @@ func downloadFile(pw *ProgressWriter, wg *sync.WaitGroup, downloadDir string, ma
 	} else if pw.Total <= 0 {
 		appLogger.Printf("%s Total size remains unknown from headers. Download will be indeterminate.", logPrefix)
 	}
+	recordSize := pw.Total
 	pw.mu.Unlock()
 	if pw.manager != nil {
 		pw.manager.requestRedraw()
 	}
+	record.mu.Lock()
+	record.Size = recordSize
+	record.mu.Unlock()
+	if err := record.save(); err != nil {
+		return fmt.Errorf("Save resume record: %v", shortenError(err, 25))
+	}
 
 	var out *os.File
 	var createErr error
@@ -905,13 +1182,28 @@ func downloadFile(pw *ProgressWriter, wg *sync.WaitGroup, downloadDir string, ma
 		out, createErr = os.Create(filePath)
 	}
 	if createErr != nil {
-		pw.MarkFinished(fmt.Sprintf("Open file '%s': %v", filePath, shortenError(createErr, 20)))
-		return
+		return fmt.Errorf("Open file '%s': %v", filePath, shortenError(createErr, 20))
 	}
 	defer out.Close()
 
+	// Hash while streaming, to check a known checksum and to record it in the
+	// manifest either way. On resume the bytes already on disk are fed to the
+	// hasher first.
+	hasher := sha256.New()
+	if isResume {
+		existing, openErr := os.Open(filePath)
+		if openErr == nil {
+			_, openErr = io.CopyN(hasher, existing, currentSize)
+			existing.Close()
+		}
+		if openErr != nil {
+			return fmt.Errorf("Hash existing: %v", shortenError(openErr, 20))
+		}
+	}
+	dst := io.MultiWriter(diskWriter{out}, hasher)
+
 	appLogger.Printf("%s Starting file copy to '%s'", logPrefix, filePath)
-	_, copyErr := io.Copy(out, io.TeeReader(resp.Body, pw))
+	_, copyErr := io.Copy(dst, io.TeeReader(throttle(resp.Body, pw.URL, resp.Request.URL), pw))
 
 	if copyErr != nil {
 		pw.mu.Lock()
@@ -920,13 +1212,29 @@ func downloadFile(pw *ProgressWriter, wg *sync.WaitGroup, downloadDir string, ma
 
 		if alreadyDone && (copyErr == io.EOF || strings.Contains(copyErr.Error(), "EOF")) {
 			appLogger.Printf("%s Copy interrupted, but already marked done. Error: %v", logPrefix, copyErr)
-		} else if strings.Contains(copyErr.Error(), "context canceled") {
-			appLogger.Printf("%s Copy interrupted by context cancellation. Not marking as error.", logPrefix)
-		} else {
-			pw.MarkFinished(fmt.Sprintf("Copy: %v", shortenError(copyErr, 25)))
+			return errDownloadCanceled
+		} else if ctxErr := downloadCtx.Err(); ctxErr != nil {
+			return ctxErr
 		}
-	} else {
-		pw.MarkFinished("") // Success
+		var writeErr *diskWriteError
+		if errors.As(copyErr, &writeErr) {
+			return fmt.Errorf("Write: %v", shortenError(writeErr.err, 25))
+		}
+		return &retryableError{err: fmt.Errorf("Copy: %v", shortenError(copyErr, 25))}
 	}
-	appLogger.Printf("%s File copy process completed for '%s'. Final status IsFinished: %t, ErrorMsg: '%s'", logPrefix, filePath, pw.IsFinished, pw.ErrorMsg)
+
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if pw.ExpectedSHA256 != "" {
+		if msg := sha256MismatchMsg(pw.ExpectedSHA256, actual); msg != "" {
+			appLogger.Printf("%s Checksum mismatch for '%s': expected %s, got %s. Removing file.", logPrefix, filePath, pw.ExpectedSHA256, actual)
+			out.Close()
+			discardPartFile(filePath, record) // Resuming from corrupt bytes can never succeed
+			return errors.New(msg)
+		}
+		appLogger.Printf("%s Checksum verified for '%s'.", logPrefix, filePath)
+	}
+	pw.mu.Lock()
+	pw.SHA256 = actual
+	pw.mu.Unlock()
+	return nil
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// globListFlag collects a repeatable glob flag such as -include or -exclude.
+type globListFlag []string
+
+func (g *globListFlag) String() string { return strings.Join(*g, ",") }
+
+func (g *globListFlag) Set(value string) error {
+	if _, err := path.Match(strings.ReplaceAll(value, "**", "*"), ""); err != nil {
+		return err
+	}
+	*g = append(*g, value)
+	return nil
+}
+
+// commaListFlag collects a repeatable flag whose values may also be comma-separated,
+// such as -shards or --tag.
+type commaListFlag []string
+
+func (c *commaListFlag) String() string { return strings.Join(*c, ",") }
+
+func (c *commaListFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*c = append(*c, item)
+		}
+	}
+	return nil
+}
+
+// fileFilter selects files by their slash-separated path within a repository or
+// download directory.
+type fileFilter struct {
+	Include []string // If set, a file must match at least one of these
+	Exclude []string // A file matching any of these is skipped
+}
+
+var downloadFilter fileFilter // Set by main.go via -include and -exclude
+
+func (f fileFilter) active() bool { return len(f.Include) > 0 || len(f.Exclude) > 0 }
+
+// matches reports whether name passes the filter.
+func (f fileFilter) matches(name string) bool {
+	name = strings.TrimPrefix(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/")
+	if len(f.Include) > 0 {
+		included := false
+		for _, pattern := range f.Include {
+			if matchGlob(pattern, name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, pattern := range f.Exclude {
+		if matchGlob(pattern, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob matches a slash-separated path against a glob. "*", "?" and "[...]" work
+// within one path segment as in path.Match, "**" matches any number of segments.
+// A pattern without a slash matches the file name in any directory, so "*.json"
+// also matches "tokenizer/vocab.json".
+func matchGlob(pattern string, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// filterHFFiles keeps the repository files whose path passes the filter.
+func filterHFFiles(files []HFFile, f fileFilter) []HFFile {
+	if !f.active() {
+		return files
+	}
+	var kept []HFFile
+	for _, file := range files {
+		if f.matches(file.Filename) {
+			kept = append(kept, file)
+		} else {
+			appLogger.Printf("[Filter] Skipping %s", file.Filename)
+		}
+	}
+	return kept
+}
+
+// filterDownloadItems keeps the items whose derived file name passes the filter.
+func filterDownloadItems(items []DownloadItem, f fileFilter) []DownloadItem {
+	if !f.active() {
+		return items
+	}
+	var kept []DownloadItem
+	for _, item := range items {
+		name := generateActualFilename(item.URL, item.PreferredFilename)
+		if f.matches(name) {
+			kept = append(kept, item)
+		} else {
+			appLogger.Printf("[Filter] Skipping %s (%s)", name, item.URL)
+		}
+	}
+	return kept
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,13 +24,14 @@ type HFApiModelInfo struct {
 	Siblings     []struct {
 		Rfilename string `json:"rfilename"`
 	} `json:"siblings,omitempty"`
-	Private     bool        `json:"private,omitempty"`
-	Gated       interface{} `json:"gated,omitempty"` // Can be bool or string like "auto", "manual"
-	Disabled    bool        `json:"disabled,omitempty"`
-	Downloads   int         `json:"downloads"`
-	Likes       int         `json:"likes"`
-	LibraryName string      `json:"library_name,omitempty"` // e.g., "transformers"
-	Spaces      []string    `json:"spaces,omitempty"`
+	Private     bool                   `json:"private,omitempty"`
+	Gated       interface{}            `json:"gated,omitempty"` // Can be bool or string like "auto", "manual"
+	Disabled    bool                   `json:"disabled,omitempty"`
+	Downloads   int                    `json:"downloads"`
+	Likes       int                    `json:"likes"`
+	LibraryName string                 `json:"library_name,omitempty"` // e.g., "transformers"
+	Spaces      []string               `json:"spaces,omitempty"`
+	CardData    map[string]interface{} `json:"cardData,omitempty"` // Model card front matter (license, base_model, ...)
 }
 
 // formatNumber formats large integers into a more readable string (e.g., 1.2K, 3.4M).
@@ -48,45 +51,172 @@ func formatNumber(n int) string {
 	return fmt.Sprintf("%.1fB", float64(n)/1000000000.0)
 }
 
-// HandleModelSearch searches for models on Hugging Face and displays popular results.
-func HandleModelSearch(query string, hfToken string) {
-	appLogger.Printf("[ModelSearch] Initiating search for query: '%s'", query)
-	fmt.Fprintf(os.Stderr, "[INFO] Searching for models matching '%s' on Hugging Face...\n", query)
+// modelSearchSorts maps the --sort values to the API's sort keys.
+var modelSearchSorts = map[string]string{
+	"downloads":    "downloads",
+	"likes":        "likes",
+	"lastModified": "lastModified",
+	"trending":     "trendingScore",
+}
+
+// modelSearchPageSize is the most results requested per page.
+const modelSearchPageSize = 100
+
+// modelSearchOptions are the query, filters and output settings of 'dl model search'.
+type modelSearchOptions struct {
+	Query          string
+	Sort           string // Key of modelSearchSorts
+	Author         string
+	Pipeline       string
+	Library        string
+	Tags           []string
+	ExcludeGated   bool
+	ExcludePrivate bool
+	Limit          int // 0 for every page
+	JSON           bool
+	Select         bool // Pick a result and open its GGUF selection menu
+	Concurrency    int  // For the selection and download after --select
+}
+
+// parseModelSearchArgs reads the query words and flags of 'dl model search', in any order.
+func parseModelSearchArgs(args []string) (modelSearchOptions, error) {
+	var opts modelSearchOptions
+	searchFlags := flag.NewFlagSet("model search", flag.ContinueOnError)
+	searchFlags.SetOutput(os.Stderr)
+	searchFlags.StringVar(&opts.Sort, "sort", "downloads", "Sort by downloads, likes, lastModified or trending (descending)")
+	searchFlags.StringVar(&opts.Author, "author", "", "Only models of this user or organization")
+	searchFlags.StringVar(&opts.Pipeline, "pipeline", "", "Only models with this pipeline tag, e.g. text-generation")
+	searchFlags.StringVar(&opts.Library, "library", "", "Only models of this library, e.g. transformers or gguf")
+	searchFlags.Var((*commaListFlag)(&opts.Tags), "tag", "Only models with this tag, e.g. gguf (repeatable or comma-separated, all must match)")
+	searchFlags.BoolVar(&opts.ExcludeGated, "exclude-gated", false, "Leave out gated models")
+	searchFlags.BoolVar(&opts.ExcludePrivate, "exclude-private", false, "Leave out private models")
+	searchFlags.IntVar(&opts.Limit, "limit", 20, "Number of results, fetched page by page (0 for all)")
+	searchFlags.BoolVar(&opts.JSON, "json", false, "Print the results as a JSON array on stdout")
+	searchFlags.BoolVar(&opts.Select, "select", false, "Pick a result by number, then select its GGUF files and download them")
+	searchFlags.IntVar(&opts.Concurrency, "c", 3, "Number of concurrent downloads after --select")
+	var words []string
+	for len(args) > 0 { // Flags may come before, between or after the query words
+		if err := searchFlags.Parse(args); err != nil {
+			return opts, err
+		}
+		args = searchFlags.Args()
+		if len(args) > 0 {
+			words, args = append(words, args[0]), args[1:]
+		}
+	}
+	opts.Query = strings.Join(words, " ")
+	if _, ok := modelSearchSorts[opts.Sort]; !ok {
+		return opts, fmt.Errorf("invalid --sort '%s' (expected downloads, likes, lastModified or trending)", opts.Sort)
+	}
+	if opts.Select && opts.JSON {
+		return opts, fmt.Errorf("--select and --json cannot be combined")
+	}
+	if opts.Limit < 0 {
+		return opts, fmt.Errorf("--limit must not be negative")
+	}
+	if opts.Query == "" && opts.Author == "" && opts.Pipeline == "" && opts.Library == "" && len(opts.Tags) == 0 {
+		return opts, fmt.Errorf("missing search query for 'model search' (or a filter such as --author or --tag)")
+	}
+	return opts, nil
+}
+
+// describe renders the search for messages, e.g. `"llama" by meta-llama, tag gguf`.
+func (opts modelSearchOptions) describe() string {
+	var parts []string
+	if opts.Query != "" {
+		parts = append(parts, fmt.Sprintf("%q", opts.Query))
+	}
+	if opts.Author != "" {
+		parts = append(parts, "by "+opts.Author)
+	}
+	if opts.Pipeline != "" {
+		parts = append(parts, "pipeline "+opts.Pipeline)
+	}
+	if opts.Library != "" {
+		parts = append(parts, "library "+opts.Library)
+	}
+	for _, tag := range opts.Tags {
+		parts = append(parts, "tag "+tag)
+	}
+	return strings.Join(parts, ", ")
+}
 
-	apiBaseURL := "https://huggingface.co/api/models"
+func (opts modelSearchOptions) apiURL() string {
 	params := url.Values{}
-	params.Add("search", query)
-	params.Add("sort", "downloads") // Sort by downloads
-	params.Add("direction", "-1")   // Descending order
-	params.Add("limit", "20")       // Limit to 20 results
-	params.Add("full", "true")      // Fetch full info to get more consistent fields like Author
+	if opts.Query != "" {
+		params.Add("search", opts.Query)
+	}
+	if opts.Author != "" {
+		params.Add("author", opts.Author)
+	}
+	if opts.Pipeline != "" {
+		params.Add("pipeline_tag", opts.Pipeline)
+	}
+	if opts.Library != "" {
+		params.Add("library", opts.Library)
+	}
+	for _, tag := range opts.Tags {
+		params.Add("filter", tag)
+	}
+	if opts.ExcludeGated {
+		params.Add("gated", "false")
+	}
+	params.Add("sort", modelSearchSorts[opts.Sort])
+	params.Add("direction", "-1") // Descending order
+	pageSize := modelSearchPageSize
+	if opts.Limit > 0 && opts.Limit < pageSize && !opts.ExcludeGated && !opts.ExcludePrivate {
+		pageSize = opts.Limit // Filtering client-side may need more than one page
+	}
+	params.Add("limit", strconv.Itoa(pageSize))
+	params.Add("full", "true") // Fetch full info to get more consistent fields like Author
 	// `full=true` is a bit slower but provides more data.
 	// `full=false` (or omitting) is faster but might miss some fields.
 	// For comprehensive display like Author, Likes, PipelineTag, `full=true` is safer.
+	return hfEndpointURL("api/models") + "?" + params.Encode()
+}
 
-	fullURL := apiBaseURL + "?" + params.Encode()
-	appLogger.Printf("[ModelSearch] Fetching from URL: %s", fullURL)
+// searchHFModels runs a search, following the Link header from page to page until
+// opts.Limit results are collected. more reports whether the Hub has further results.
+func searchHFModels(opts modelSearchOptions, hfToken string) (results []HFApiModelInfo, more bool, err error) {
+	client := http.Client{Timeout: 45 * time.Second, CheckRedirect: hfCheckRedirect} // Increased timeout for potentially larger "full=true" responses
+	pageURL := opts.apiURL()
+	for pageURL != "" {
+		appLogger.Printf("[ModelSearch] Fetching from URL: %s", pageURL)
+		page, next, err := fetchModelSearchPage(&client, pageURL, hfToken)
+		if err != nil {
+			return results, false, err
+		}
+		for i, model := range page {
+			if gated, _ := hfIsGated(model.Gated); (gated && opts.ExcludeGated) || (model.Private && opts.ExcludePrivate) {
+				continue
+			}
+			if opts.Limit > 0 && len(results) >= opts.Limit {
+				return results, true, nil
+			}
+			results = append(results, model)
+			if opts.Limit > 0 && len(results) >= opts.Limit {
+				return results, i+1 < len(page) || next != "", nil
+			}
+		}
+		pageURL = next
+	}
+	return results, false, nil
+}
 
-	client := http.Client{Timeout: 45 * time.Second} // Increased timeout for potentially larger "full=true" responses
-	req, err := http.NewRequest("GET", fullURL, nil)
+func fetchModelSearchPage(client *http.Client, pageURL string, hfToken string) ([]HFApiModelInfo, string, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
 	if err != nil {
-		appLogger.Printf("[ModelSearch] Error creating request: %v", err)
-		fmt.Fprintf(os.Stderr, "[ERROR] Failed to create search request: %v\n", err)
-		return
+		return nil, "", fmt.Errorf("failed to create search request: %w", err)
 	}
 	req.Header.Set("User-Agent", "go-downloader-app/1.0 (model-search)") // Polite to set User-Agent
 	req.Header.Set("Accept", "application/json")
-
 	if hfToken != "" {
 		req.Header.Set("Authorization", "Bearer "+hfToken)
-		appLogger.Printf("[ModelSearch] Using Hugging Face token for API request to %s", fullURL)
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		appLogger.Printf("[ModelSearch] Error performing request: %v", err)
-		fmt.Fprintf(os.Stderr, "[ERROR] Failed to search Hugging Face: %v\n", err)
-		return
+		return nil, "", fmt.Errorf("failed to search Hugging Face: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -99,32 +229,122 @@ func HandleModelSearch(query string, hfToken string) {
 			errorDetail = string(bodyBytes)
 			appLogger.Printf("[ModelSearch] API error response body: %s", errorDetail)
 		}
-		fmt.Fprintf(os.Stderr, "[ERROR] Hugging Face API request failed: %s. Detail: %s\n", resp.Status, errorDetail)
-		return
+		return nil, "", fmt.Errorf("Hugging Face API request failed: %s. Detail: %s", resp.Status, errorDetail)
 	}
 
-	var results []HFApiModelInfo
-	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
-		appLogger.Printf("[ModelSearch] Error decoding JSON response: %v", err)
-		fmt.Fprintf(os.Stderr, "[ERROR] Failed to parse search results: %v\n", err)
-		return
+	var page []HFApiModelInfo
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", fmt.Errorf("failed to parse search results: %w", err)
+	}
+	return page, nextPageURL(resp), nil
+}
+
+// HandleModelSearch implements 'dl model search <query> [flags]': it searches for
+// models on Hugging Face and displays the results, or prints them as JSON.
+func HandleModelSearch(args []string, hfToken string) bool {
+	opts, err := parseModelSearchArgs(args)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return false
 	}
+	query := opts.describe()
+	appLogger.Printf("[ModelSearch] Initiating search for %s (sort %s, limit %d)", query, opts.Sort, opts.Limit)
+	fmt.Fprintf(os.Stderr, "[INFO] Searching for models matching %s on Hugging Face...\n", query)
 
+	results, more, err := searchHFModels(opts, hfToken)
+	if err != nil {
+		appLogger.Printf("[ModelSearch] %v", err)
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+
+	if opts.JSON {
+		if results == nil {
+			results = []HFApiModelInfo{} // An empty array rather than null
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not encode JSON: %v\n", err)
+			return false
+		}
+		if more {
+			fmt.Fprintf(os.Stderr, "[INFO] %d result(s); more are available with a higher --limit.\n", len(results))
+		}
+		return true
+	}
+	printModelSearchResults(results, opts, more)
+	if opts.Select {
+		return downloadSearchResult(results, opts, hfToken)
+	}
+	return true
+}
+
+// downloadSearchResult asks which result to download and runs the -hf -select
+// pipeline for it, with the search's token and concurrency.
+func downloadSearchResult(results []HFApiModelInfo, opts modelSearchOptions, hfToken string) bool {
 	if len(results) == 0 {
-		fmt.Fprintf(os.Stderr, "[INFO] No models found matching your query '%s'.\n", query)
-		appLogger.Printf("[ModelSearch] No results for query '%s'", query)
+		return true
+	}
+	choice := pickSearchResult(len(results))
+	if choice == 0 {
+		fmt.Fprintln(os.Stderr, "[INFO] No model selected.")
+		return true
+	}
+	model := results[choice-1]
+	appLogger.Printf("[ModelSearch] Picked result %d: %s", choice, model.ModelID)
+	concurrency := hfConcurrency(opts.Concurrency, true)
+	plan, err := prepareHFDownload(hfDownloadRequest{
+		RepoInput:   model.ModelID,
+		Revision:    defaultHFRevision,
+		Select:      true,
+		Concurrency: concurrency,
+		ConfirmAll:  true,
+	}, hfToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false
+	}
+	if plan == nil {
+		return true
+	}
+	return runDownloads(plan, concurrency, hfToken) == 0
+}
+
+// pickSearchResult asks for the number of a result; 0 means none was picked.
+func pickSearchResult(count int) int {
+	for {
+		fmt.Fprintf(os.Stderr, "Enter the number of a model to download (1-%d), or press Enter to quit: ", count)
+		userInput, readErr := stdinReader.ReadString('\n')
+		userInput = strings.TrimSpace(userInput)
+		if userInput == "" {
+			return 0
+		}
+		if choice, err := strconv.Atoi(userInput); err == nil && choice >= 1 && choice <= count {
+			return choice
+		}
+		fmt.Fprintf(os.Stderr, "[ERROR] Invalid input: '%s'. Please enter a number from 1 to %d.\n", userInput, count)
+		if readErr != nil { // End of input
+			return 0
+		}
+	}
+}
+
+// printModelSearchResults prints numbered result blocks.
+func printModelSearchResults(results []HFApiModelInfo, opts modelSearchOptions, more bool) {
+	query := opts.describe()
+	if len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "[INFO] No models found matching %s.\n", query)
+		appLogger.Printf("[ModelSearch] No results for %s", query)
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, "\nTop %d model results for \"%s\" (sorted by downloads):\n", len(results), query)
+	fmt.Fprintf(os.Stderr, "\nTop %d model results for %s (sorted by %s):\n", len(results), query, opts.Sort)
 	fmt.Println(strings.Repeat("=", 80))
 
 	for i, model := range results {
-		// The API already limits to 20, but this is a safeguard.
-		if i >= 20 {
-			break
-		}
-
 		// Determine Author: Use model.Author if present, otherwise derive from modelId
 		authorDisplay := model.Author
 		if authorDisplay == "" {
@@ -198,11 +418,10 @@ func HandleModelSearch(query string, hfToken string) {
 		fmt.Println(strings.Repeat("-", 40)) // Separator for each model entry
 	}
 
-	if len(results) < 20 && len(results) > 0 {
+	if more {
+		fmt.Fprintf(os.Stderr, "\nShowing the top %d models. More results are available with a higher --limit (0 for all).\n", len(results))
+	} else {
 		fmt.Fprintf(os.Stderr, "\nFound %d model(s).\n", len(results))
-	} else if len(results) >= 20 {
-		// The API was asked for 20, so if we get 20, it implies it might be the limit.
-		fmt.Fprintf(os.Stderr, "\nShowing the top %d models. More results might be available on Hugging Face.\n", len(results))
 	}
-	appLogger.Printf("[ModelSearch] Successfully displayed %d results for query '%s'", len(results), query)
+	appLogger.Printf("[ModelSearch] Successfully displayed %d results for %s", len(results), query)
 }
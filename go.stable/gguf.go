@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GGUF metadata value types, as defined by the GGUF specification.
+const (
+	ggufTypeUint8   uint32 = 0
+	ggufTypeInt8    uint32 = 1
+	ggufTypeUint16  uint32 = 2
+	ggufTypeInt16   uint32 = 3
+	ggufTypeUint32  uint32 = 4
+	ggufTypeInt32   uint32 = 5
+	ggufTypeFloat32 uint32 = 6
+	ggufTypeBool    uint32 = 7
+	ggufTypeString  uint32 = 8
+	ggufTypeArray   uint32 = 9
+	ggufTypeUint64  uint32 = 10
+	ggufTypeInt64   uint32 = 11
+	ggufTypeFloat64 uint32 = 12
+)
+
+var ggufTypeNames = map[uint32]string{
+	ggufTypeUint8: "uint8", ggufTypeInt8: "int8", ggufTypeUint16: "uint16", ggufTypeInt16: "int16",
+	ggufTypeUint32: "uint32", ggufTypeInt32: "int32", ggufTypeFloat32: "float32", ggufTypeBool: "bool",
+	ggufTypeString: "string", ggufTypeArray: "array", ggufTypeUint64: "uint64", ggufTypeInt64: "int64",
+	ggufTypeFloat64: "float64",
+}
+
+// ggufFileTypeNames maps general.file_type (llama.cpp's llama_ftype) to the
+// quantization names used in file names.
+var ggufFileTypeNames = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16", 36: "TQ1_0",
+	37: "TQ2_0", 38: "MXFP4_MOE",
+}
+
+// ggmlTypeNames maps the tensor types (ggml_type) to their names.
+var ggmlTypeNames = map[uint32]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 6: "Q5_0", 7: "Q5_1", 8: "Q8_0", 9: "Q8_1",
+	10: "Q2_K", 11: "Q3_K", 12: "Q4_K", 13: "Q5_K", 14: "Q6_K", 15: "Q8_K", 16: "IQ2_XXS",
+	17: "IQ2_XS", 18: "IQ3_XXS", 19: "IQ1_S", 20: "IQ4_NL", 21: "IQ3_S", 22: "IQ2_S",
+	23: "IQ4_XS", 24: "I8", 25: "I16", 26: "I32", 27: "I64", 28: "F64", 29: "IQ1_M",
+	30: "BF16", 34: "TQ1_0", 35: "TQ2_0", 39: "MXFP4",
+}
+
+// ggufMaxArrayValues is how many elements of an array are kept; tokenizer arrays
+// have hundreds of thousands of entries, which are only counted.
+const ggufMaxArrayValues = 16
+
+// ggufHeaderProbeBytes is how much of a remote file the -select menu reads: enough
+// for the general.* and architecture keys, which precede the tokenizer arrays.
+const ggufHeaderProbeBytes = 256 * 1024
+
+// errGGUFReadLimit reports that a partial read stopped at its byte limit; the
+// metadata parsed up to that point is still returned.
+var errGGUFReadLimit = errors.New("read limit reached")
+
+// GGUFKV is one metadata key/value pair. Arrays keep their first
+// ggufMaxArrayValues elements in Value and their full length in ArrayLen.
+type GGUFKV struct {
+	Key       string      `json:"key"`
+	Type      string      `json:"type"`
+	Value     interface{} `json:"value"`
+	ArrayType string      `json:"array_type,omitempty"`
+	ArrayLen  uint64      `json:"array_len,omitempty"`
+	raw       []byte      // Encoded key/value as in the file, kept for rewriting it (merge)
+}
+
+// GGUFTensorInfo describes one tensor of the file.
+type GGUFTensorInfo struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Shape  []uint64 `json:"shape"`
+	Offset uint64   `json:"offset"`
+	typeID uint32
+}
+
+// GGUFMetadata is the parsed header of a GGUF file.
+type GGUFMetadata struct {
+	Version        uint32           `json:"version"`
+	BigEndian      bool             `json:"big_endian,omitempty"`
+	TensorCount    uint64           `json:"tensor_count"`
+	KV             []GGUFKV         `json:"metadata"`
+	Tensors        []GGUFTensorInfo `json:"tensors,omitempty"`
+	ParameterCount uint64           `json:"parameter_count,omitempty"` // Sum over Tensors, 0 if they were not read
+	Truncated      bool             `json:"truncated,omitempty"`       // Only the start of the header was read
+	headerSize     int64            // Bytes up to the end of the tensor descriptions
+}
+
+// Get returns the value of a metadata key.
+func (m *GGUFMetadata) Get(key string) (interface{}, bool) {
+	for _, kv := range m.KV {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// String returns a string value, or "" if the key is missing or not a string.
+func (m *GGUFMetadata) String(key string) string {
+	value, _ := m.Get(key)
+	s, _ := value.(string)
+	return s
+}
+
+// Uint returns an integer value of any width, or 0 if the key is missing.
+func (m *GGUFMetadata) Uint(key string) uint64 {
+	value, ok := m.Get(key)
+	if !ok {
+		return 0
+	}
+	switch v := value.(type) {
+	case uint8:
+		return uint64(v)
+	case int8:
+		return uint64(max(v, 0))
+	case uint16:
+		return uint64(v)
+	case int16:
+		return uint64(max(v, 0))
+	case uint32:
+		return uint64(v)
+	case int32:
+		return uint64(max(v, 0))
+	case uint64:
+		return v
+	case int64:
+		return uint64(max(v, 0))
+	}
+	return 0
+}
+
+// Architecture returns general.architecture, e.g. "llama" or "qwen3".
+func (m *GGUFMetadata) Architecture() string { return m.String("general.architecture") }
+
+// ArchUint returns an architecture-specific integer, e.g. ArchUint("context_length")
+// reads llama.context_length for a llama model.
+func (m *GGUFMetadata) ArchUint(key string) uint64 {
+	return m.Uint(m.Architecture() + "." + key)
+}
+
+// FileType returns the quantization name of general.file_type, e.g. "Q4_K_M".
+func (m *GGUFMetadata) FileType() string {
+	if _, ok := m.Get("general.file_type"); !ok {
+		return ""
+	}
+	fileType := m.Uint("general.file_type")
+	if name, ok := ggufFileTypeNames[fileType]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", fileType)
+}
+
+// SizeLabel returns general.size_label (e.g. "8B", "30B-A3B"), else the parameter
+// count of the tensors when the whole model is in this file.
+func (m *GGUFMetadata) SizeLabel() string {
+	if label := m.String("general.size_label"); label != "" {
+		return label
+	}
+	if m.ParameterCount > 0 && m.Uint("split.count") <= 1 {
+		return formatParameterCount(m.ParameterCount)
+	}
+	return ""
+}
+
+// Summary is a one-line description, e.g. "qwen3 8B, ctx 40960, Q4_K_M".
+func (m *GGUFMetadata) Summary() string {
+	var parts []string
+	head := m.Architecture()
+	if label := m.SizeLabel(); label != "" {
+		head = strings.TrimSpace(head + " " + label)
+	}
+	if head != "" {
+		parts = append(parts, head)
+	}
+	if ctx := m.ArchUint("context_length"); ctx > 0 {
+		parts = append(parts, fmt.Sprintf("ctx %d", ctx))
+	}
+	if fileType := m.FileType(); fileType != "" {
+		parts = append(parts, fileType)
+	}
+	if experts := m.ArchUint("expert_count"); experts > 0 {
+		parts = append(parts, fmt.Sprintf("%d experts (%d active)", experts, m.ArchUint("expert_used_count")))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatParameterCount formats e.g. 8190735360 as "8.19B".
+func formatParameterCount(n uint64) string {
+	switch {
+	case n >= 1e12:
+		return fmt.Sprintf("%.2fT", float64(n)/1e12)
+	case n >= 1e9:
+		return fmt.Sprintf("%.2fB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.0fM", float64(n)/1e6)
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// ggufReader decodes the primitive types of a GGUF header.
+type ggufReader struct {
+	r       *bufio.Reader
+	order   binary.ByteOrder
+	offset  int64         // Bytes read so far
+	capture *bytes.Buffer // Receives the bytes read while set
+}
+
+func (g *ggufReader) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read, err := io.ReadFull(g.r, buf)
+	g.offset += int64(read)
+	if err != nil {
+		return nil, err
+	}
+	if g.capture != nil {
+		g.capture.Write(buf)
+	}
+	return buf, nil
+}
+
+func (g *ggufReader) uint32() (uint32, error) {
+	buf, err := g.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return g.order.Uint32(buf), nil
+}
+
+func (g *ggufReader) uint64() (uint64, error) {
+	buf, err := g.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return g.order.Uint64(buf), nil
+}
+
+func (g *ggufReader) string() (string, error) {
+	length, err := g.uint64()
+	if err != nil {
+		return "", err
+	}
+	if length > 1<<28 {
+		return "", fmt.Errorf("implausible string length %d", length)
+	}
+	buf, err := g.read(int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// value reads one scalar or string of the given type.
+func (g *ggufReader) value(valueType uint32) (interface{}, error) {
+	switch valueType {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		buf, err := g.read(1)
+		if err != nil {
+			return nil, err
+		}
+		switch valueType {
+		case ggufTypeInt8:
+			return int8(buf[0]), nil
+		case ggufTypeBool:
+			return buf[0] != 0, nil
+		}
+		return buf[0], nil
+	case ggufTypeUint16, ggufTypeInt16:
+		buf, err := g.read(2)
+		if err != nil {
+			return nil, err
+		}
+		if valueType == ggufTypeInt16 {
+			return int16(g.order.Uint16(buf)), nil
+		}
+		return g.order.Uint16(buf), nil
+	case ggufTypeUint32, ggufTypeInt32, ggufTypeFloat32:
+		v, err := g.uint32()
+		if err != nil {
+			return nil, err
+		}
+		switch valueType {
+		case ggufTypeInt32:
+			return int32(v), nil
+		case ggufTypeFloat32:
+			return float64(math.Float32frombits(v)), nil
+		}
+		return v, nil
+	case ggufTypeUint64, ggufTypeInt64, ggufTypeFloat64:
+		v, err := g.uint64()
+		if err != nil {
+			return nil, err
+		}
+		switch valueType {
+		case ggufTypeInt64:
+			return int64(v), nil
+		case ggufTypeFloat64:
+			return math.Float64frombits(v), nil
+		}
+		return v, nil
+	case ggufTypeString:
+		return g.string()
+	}
+	return nil, fmt.Errorf("unknown value type %d", valueType)
+}
+
+// kv reads one metadata key/value pair, keeping its encoding if keepRaw is set.
+func (g *ggufReader) kv(keepRaw bool) (kv GGUFKV, err error) {
+	if keepRaw {
+		g.capture = &bytes.Buffer{}
+		defer func() {
+			kv.raw = g.capture.Bytes()
+			g.capture = nil
+		}()
+	}
+	key, err := g.string()
+	if err != nil {
+		return kv, err
+	}
+	kv.Key = key
+	valueType, err := g.uint32()
+	if err != nil {
+		return kv, err
+	}
+	kv.Type = ggufTypeNames[valueType]
+	if valueType != ggufTypeArray {
+		kv.Value, err = g.value(valueType)
+		if err != nil {
+			return kv, fmt.Errorf("key %s: %w", key, err)
+		}
+		return kv, nil
+	}
+
+	elemType, err := g.uint32()
+	if err != nil {
+		return kv, err
+	}
+	count, err := g.uint64()
+	if err != nil {
+		return kv, err
+	}
+	if elemType == ggufTypeArray {
+		return kv, fmt.Errorf("key %s: nested arrays are not supported", key)
+	}
+	kv.ArrayType = ggufTypeNames[elemType]
+	kv.ArrayLen = count
+	values := []interface{}{}
+	for i := uint64(0); i < count; i++ {
+		v, err := g.value(elemType)
+		if err != nil {
+			return kv, fmt.Errorf("key %s[%d]: %w", key, i, err)
+		}
+		if i < ggufMaxArrayValues {
+			values = append(values, v)
+		}
+	}
+	kv.Value = values
+	return kv, nil
+}
+
+// parseGGUF reads the header of a GGUF file: the metadata and, if withTensors is
+// set, the tensor descriptions. If reading stops early, the metadata read so far is
+// returned together with the error.
+func parseGGUF(r io.Reader, withTensors bool) (*GGUFMetadata, error) {
+	return parseGGUFHeader(r, withTensors, false)
+}
+
+// parseGGUFHeader is parseGGUF that can also keep the encoded key/value pairs,
+// which a merge copies into the new file.
+func parseGGUFHeader(r io.Reader, withTensors bool, keepRaw bool) (*GGUFMetadata, error) {
+	g := &ggufReader{r: bufio.NewReaderSize(r, 64*1024), order: binary.LittleEndian}
+	magic, err := g.read(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading GGUF magic: %w", err)
+	}
+	if string(magic) != "GGUF" {
+		return nil, fmt.Errorf("not a GGUF file (magic %q)", magic)
+	}
+	versionBytes, err := g.read(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading GGUF version: %w", err)
+	}
+	md := &GGUFMetadata{Version: binary.LittleEndian.Uint32(versionBytes)}
+	if md.Version > 0xffff { // Written on a big-endian host
+		g.order = binary.BigEndian
+		md.Version = binary.BigEndian.Uint32(versionBytes)
+		md.BigEndian = true
+	}
+	if md.Version < 2 || md.Version > 3 {
+		return nil, fmt.Errorf("unsupported GGUF version %d", md.Version)
+	}
+	if md.TensorCount, err = g.uint64(); err != nil {
+		return nil, fmt.Errorf("reading tensor count: %w", err)
+	}
+	kvCount, err := g.uint64()
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata count: %w", err)
+	}
+	for i := uint64(0); i < kvCount; i++ {
+		kv, err := g.kv(keepRaw)
+		if err != nil {
+			md.Truncated = true
+			return md, fmt.Errorf("reading metadata %d of %d: %w", i+1, kvCount, err)
+		}
+		md.KV = append(md.KV, kv)
+	}
+	if !withTensors {
+		return md, nil
+	}
+
+	for i := uint64(0); i < md.TensorCount; i++ {
+		tensor, err := g.tensorInfo()
+		if err != nil {
+			md.Truncated = true
+			return md, fmt.Errorf("reading tensor %d of %d: %w", i+1, md.TensorCount, err)
+		}
+		params := uint64(1)
+		for _, dim := range tensor.Shape {
+			params *= dim
+		}
+		md.ParameterCount += params
+		md.Tensors = append(md.Tensors, tensor)
+	}
+	md.headerSize = g.offset
+	return md, nil
+}
+
+func (g *ggufReader) tensorInfo() (GGUFTensorInfo, error) {
+	var tensor GGUFTensorInfo
+	name, err := g.string()
+	if err != nil {
+		return tensor, err
+	}
+	tensor.Name = name
+	dims, err := g.uint32()
+	if err != nil {
+		return tensor, err
+	}
+	if dims > 8 {
+		return tensor, fmt.Errorf("tensor %s: implausible dimension count %d", name, dims)
+	}
+	for d := uint32(0); d < dims; d++ {
+		dim, err := g.uint64()
+		if err != nil {
+			return tensor, err
+		}
+		tensor.Shape = append(tensor.Shape, dim)
+	}
+	tensorType, err := g.uint32()
+	if err != nil {
+		return tensor, err
+	}
+	tensor.typeID = tensorType
+	tensor.Type = ggmlTypeNames[tensorType]
+	if tensor.Type == "" {
+		tensor.Type = fmt.Sprintf("type %d", tensorType)
+	}
+	tensor.Offset, err = g.uint64()
+	return tensor, err
+}
+
+// readGGUFFile parses the header of a local GGUF file.
+func readGGUFFile(filePath string, withTensors bool) (*GGUFMetadata, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseGGUF(f, withTensors)
+}
+
+// fetchGGUFMetadata parses the header of a remote GGUF file with Range requests,
+// without downloading the tensor data. With a limit > 0 at most that many bytes are
+// read; the metadata up to that point is returned with Truncated set.
+func fetchGGUFMetadata(fileURL string, hfToken string, limit int64, withTensors bool) (*GGUFMetadata, error) {
+	rr := &httpRangeReader{url: fileURL, token: hfToken, limit: limit, chunk: ggufHeaderProbeBytes}
+	defer rr.Close()
+	md, err := parseGGUF(rr, withTensors)
+	if err != nil && md != nil && errors.Is(err, errGGUFReadLimit) {
+		appLogger.Printf("[GGUF] Read the first %d bytes of %s: %d metadata keys.", limit, fileURL, len(md.KV))
+		return md, nil
+	}
+	return md, err
+}
+
+// httpRangeReader reads a remote file sequentially with Range requests of growing
+// size, so parsing a header transfers little more than the header itself.
+type httpRangeReader struct {
+	url    string
+	token  string
+	limit  int64 // Stop with errGGUFReadLimit after this many bytes, 0 for no limit
+	chunk  int64 // Size of the next request, doubled up to 8 MB
+	offset int64
+	size   int64 // From Content-Range, 0 until known
+	body   io.ReadCloser
+	client *http.Client
+}
+
+func (rr *httpRangeReader) Read(p []byte) (int, error) {
+	for {
+		if rr.limit > 0 && rr.offset >= rr.limit {
+			return 0, errGGUFReadLimit
+		}
+		if rr.body == nil {
+			if rr.size > 0 && rr.offset >= rr.size {
+				return 0, io.EOF
+			}
+			if err := rr.open(); err != nil {
+				return 0, err
+			}
+		}
+		if rr.limit > 0 && int64(len(p)) > rr.limit-rr.offset {
+			p = p[:rr.limit-rr.offset]
+		}
+		n, err := rr.body.Read(p)
+		rr.offset += int64(n)
+		if err == io.EOF {
+			rr.body.Close()
+			rr.body = nil
+			if n > 0 {
+				return n, nil
+			}
+			if rr.size == 0 { // The server sent the whole file, which has ended
+				return 0, io.EOF
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (rr *httpRangeReader) open() error {
+	if rr.client == nil {
+		rr.client = newDownloadClient("[GGUF]")
+	}
+	end := rr.offset + rr.chunk - 1
+	if rr.limit > 0 && end >= rr.limit {
+		end = rr.limit - 1
+	}
+	req, err := newDownloadRequest(rr.url, rr.token) // The token only goes to Hugging Face hosts
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rr.offset, end))
+	resp, err := rr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		contentRange := resp.Header.Get("Content-Range") // bytes 0-262143/4920734016
+		if slash := strings.LastIndex(contentRange, "/"); slash >= 0 {
+			if total, err := strconv.ParseInt(contentRange[slash+1:], 10, 64); err == nil {
+				rr.size = total
+			}
+		}
+		rr.body = resp.Body
+		if rr.chunk < 8<<20 {
+			rr.chunk *= 2
+		}
+	case http.StatusOK:
+		if rr.offset > 0 {
+			resp.Body.Close()
+			return fmt.Errorf("%s does not support Range requests", rr.url)
+		}
+		appLogger.Printf("[GGUF] %s ignored the Range header, reading the start of the full response.", rr.url)
+		rr.body = resp.Body
+		rr.size = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		rr.size = rr.offset
+		return io.EOF
+	default:
+		defer resp.Body.Close()
+		return httpStatusError(resp)
+	}
+	return nil
+}
+
+// Close releases the response that is being read, if any.
+func (rr *httpRangeReader) Close() error {
+	if rr.body != nil {
+		err := rr.body.Close()
+		rr.body = nil
+		return err
+	}
+	return nil
+}
+
+// tensorTypeCounts summarizes the tensor types, most frequent first, e.g.
+// "Q4_K x 216, F32 x 113, Q6_K x 37".
+func (m *GGUFMetadata) tensorTypeCounts() string {
+	counts := make(map[string]int)
+	for _, tensor := range m.Tensors {
+		counts[tensor.Type]++
+	}
+	return formatTypeCounts(counts)
+}
+
+// formatTypeCounts renders type counts most frequent first, e.g. "BF16 x 10, F32 x 2".
+func formatTypeCounts(counts map[string]int) string {
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if counts[types[i]] != counts[types[j]] {
+			return counts[types[i]] > counts[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	var parts []string
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%s x %d", t, counts[t]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// mergeSplits makes a validated split GGUF download get merged into one file,
+// set by main.go via -merge.
+var mergeSplits bool
+
+// ggufSplitKeys are the metadata keys gguf-split adds to every part.
+var ggufSplitKeys = map[string]bool{"split.no": true, "split.count": true, "split.tensors.count": true}
+
+// ggufSplitSeries is one downloaded split GGUF, e.g. model-00001-of-00003.gguf and
+// its siblings.
+type ggufSplitSeries struct {
+	BaseName string   // Path in the download directory without -0000N-of-0000M.gguf
+	Total    int      // N of -of-N
+	Parts    []string // Path of part i+1, "" if it is missing
+	Problems []string
+}
+
+func (s *ggufSplitSeries) partPath(downloadDir string, partNum int) string {
+	return filepath.Join(downloadDir, filepath.FromSlash(fmt.Sprintf("%s-%05d-of-%05d.gguf", s.BaseName, partNum, s.Total)))
+}
+
+// validateGGUFSplits checks every split GGUF series among the downloads: that all
+// parts of -of-N are on disk and that each part's header names its position
+// (split.no, split.count) and agrees on the total tensor count. Problems are
+// printed and recorded in the returned series.
+func validateGGUFSplits(downloadDir string, pws []*ProgressWriter) []*ggufSplitSeries {
+	seriesByKey := make(map[string]*ggufSplitSeries)
+	var seriesList []*ggufSplitSeries
+	failed := make(map[string]string) // Part path -> download error
+	for _, pw := range pws {
+		if pw == nil {
+			continue
+		}
+		matches := ggufSeriesRegex.FindStringSubmatch(filepath.ToSlash(pw.ActualFileName))
+		if len(matches) != 4 {
+			continue
+		}
+		total, _ := strconv.Atoi(matches[3])
+		if total < 1 {
+			continue
+		}
+		key := matches[1] + "/" + matches[3]
+		series, ok := seriesByKey[key]
+		if !ok {
+			series = &ggufSplitSeries{BaseName: matches[1], Total: total}
+			seriesByKey[key] = series
+			seriesList = append(seriesList, series)
+		}
+		pw.mu.Lock()
+		if pw.ErrorMsg != "" {
+			failed[filepath.Join(downloadDir, pw.ActualFileName)] = pw.ErrorMsg
+		}
+		pw.mu.Unlock()
+	}
+	sort.Slice(seriesList, func(i, j int) bool { return seriesList[i].BaseName < seriesList[j].BaseName })
+
+	for _, series := range seriesList {
+		series.Parts = make([]string, series.Total)
+		var tensorsInParts, tensorsTotal uint64
+		for partNum := 1; partNum <= series.Total; partNum++ {
+			partPath := series.partPath(downloadDir, partNum)
+			if errMsg, ok := failed[partPath]; ok {
+				series.Problems = append(series.Problems, fmt.Sprintf("part %d failed to download (%s)", partNum, errMsg))
+				continue
+			}
+			if _, err := os.Stat(partPath); err != nil {
+				series.Problems = append(series.Problems, fmt.Sprintf("part %d is missing", partNum))
+				continue
+			}
+			md, err := readGGUFFile(partPath, false)
+			if err != nil {
+				series.Problems = append(series.Problems, fmt.Sprintf("part %d has an unreadable header: %v", partNum, err))
+				continue
+			}
+			series.Parts[partNum-1] = partPath
+			if _, ok := md.Get("split.count"); !ok {
+				series.Problems = append(series.Problems, fmt.Sprintf("part %d has no split metadata (not written by gguf-split)", partNum))
+				continue
+			}
+			if no := md.Uint("split.no"); no != uint64(partNum-1) {
+				series.Problems = append(series.Problems, fmt.Sprintf("part %d says it is part %d (split.no=%d)", partNum, no+1, no))
+			}
+			if count := md.Uint("split.count"); count != uint64(series.Total) {
+				series.Problems = append(series.Problems, fmt.Sprintf("part %d says the split has %d parts, the file name %d", partNum, count, series.Total))
+			}
+			if count := md.Uint("split.tensors.count"); tensorsTotal == 0 {
+				tensorsTotal = count
+			} else if count != tensorsTotal {
+				series.Problems = append(series.Problems, fmt.Sprintf("part %d disagrees on the total tensor count (%d, not %d)", partNum, count, tensorsTotal))
+			}
+			tensorsInParts += md.TensorCount
+		}
+		if len(series.Problems) == 0 && tensorsTotal > 0 && tensorsInParts != tensorsTotal {
+			series.Problems = append(series.Problems, fmt.Sprintf("the parts hold %d tensors, the header announces %d", tensorsInParts, tensorsTotal))
+		}
+
+		if len(series.Problems) == 0 {
+			appLogger.Printf("[GGUFSplit] %s: all %d parts present and consistent.", series.BaseName, series.Total)
+			fmt.Fprintf(os.Stderr, "[INFO] Split GGUF %s: all %d parts present and consistent.\n", series.BaseName, series.Total)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "[ERROR] Split GGUF %s (%d parts) is incomplete or inconsistent:\n", series.BaseName, series.Total)
+		for _, problem := range series.Problems {
+			appLogger.Printf("[GGUFSplit] %s: %s", series.BaseName, problem)
+			fmt.Fprintf(os.Stderr, "        - %s\n", problem)
+		}
+	}
+	return seriesList
+}
+
+// ggufTensorSpan locates one tensor's data (including its alignment padding) in a part.
+type ggufTensorSpan struct {
+	info   GGUFTensorInfo
+	file   *os.File
+	offset int64
+	size   int64
+}
+
+// countingWriter counts the bytes written through it. It keeps the first write
+// error and skips every later write, so a run of writes needs one check of err.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) (int, error) { return c.Write([]byte(s)) }
+
+func ggufAlign(n int64, alignment int64) int64 {
+	return (n + alignment - 1) / alignment * alignment
+}
+
+// mergeGGUFSplit writes the parts of a validated series into <BaseName>.gguf the way
+// 'llama-gguf-split --merge' does: the metadata of the first part without the
+// split.* keys, the tensor descriptions of all parts with new offsets, then the
+// tensor data of every part in order.
+func mergeGGUFSplit(downloadDir string, series *ggufSplitSeries) (string, error) {
+	outPath := filepath.Join(downloadDir, filepath.FromSlash(series.BaseName+".gguf"))
+	var firstMD *GGUFMetadata
+	var spans []ggufTensorSpan
+	alignment := int64(32) // GGUF_DEFAULT_ALIGNMENT
+
+	for i, partPath := range series.Parts {
+		f, err := os.Open(partPath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		md, err := parseGGUFHeader(f, true, i == 0)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", filepath.Base(partPath), err)
+		}
+		if md.BigEndian {
+			return "", fmt.Errorf("%s: merging big-endian GGUF files is not supported", filepath.Base(partPath))
+		}
+		if i == 0 {
+			firstMD = md
+			if a := md.Uint("general.alignment"); a > 0 {
+				alignment = int64(a)
+			}
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return "", err
+		}
+		dataStart := ggufAlign(md.headerSize, alignment)
+		// A tensor's data runs up to the next tensor's offset (or the end of the file),
+		// which includes the padding to the alignment.
+		order := make([]int, len(md.Tensors))
+		for t := range order {
+			order[t] = t
+		}
+		sort.Slice(order, func(a, b int) bool { return md.Tensors[order[a]].Offset < md.Tensors[order[b]].Offset })
+		sizes := make([]int64, len(md.Tensors))
+		for k, t := range order {
+			end := info.Size() - dataStart
+			if k+1 < len(order) {
+				end = int64(md.Tensors[order[k+1]].Offset)
+			}
+			sizes[t] = end - int64(md.Tensors[t].Offset)
+			if sizes[t] < 0 || dataStart+end > info.Size() {
+				return "", fmt.Errorf("%s: tensor %s lies outside the file", filepath.Base(partPath), md.Tensors[t].Name)
+			}
+		}
+		for t, tensor := range md.Tensors {
+			spans = append(spans, ggufTensorSpan{info: tensor, file: f, offset: dataStart + int64(tensor.Offset), size: sizes[t]})
+		}
+	}
+
+	tmpPath := outPath + ".part"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	bw := bufio.NewWriterSize(out, 4<<20)
+	w := &countingWriter{w: bw}
+	writeErr := func() error {
+		var kvs []GGUFKV
+		for _, kv := range firstMD.KV {
+			if !ggufSplitKeys[kv.Key] {
+				kvs = append(kvs, kv)
+			}
+		}
+		// binary.Write and w.Write errors are latched in w and checked below.
+		le := binary.LittleEndian
+		w.WriteString("GGUF")
+		binary.Write(w, le, firstMD.Version) // The layout is the same for versions 2 and 3
+		binary.Write(w, le, uint64(len(spans)))
+		binary.Write(w, le, uint64(len(kvs)))
+		for _, kv := range kvs {
+			w.Write(kv.raw)
+		}
+		var offset int64
+		for _, span := range spans {
+			binary.Write(w, le, uint64(len(span.info.Name)))
+			w.WriteString(span.info.Name)
+			binary.Write(w, le, uint32(len(span.info.Shape)))
+			for _, dim := range span.info.Shape {
+				binary.Write(w, le, dim)
+			}
+			binary.Write(w, le, span.info.typeID)
+			binary.Write(w, le, uint64(offset))
+			offset += ggufAlign(span.size, alignment)
+		}
+		w.Write(make([]byte, ggufAlign(w.n, alignment)-w.n))
+		if w.err != nil {
+			return fmt.Errorf("writing the header: %w", w.err)
+		}
+
+		var currentPart *os.File
+		for _, span := range spans {
+			if span.file != currentPart {
+				currentPart = span.file
+				fmt.Fprintf(os.Stderr, "[INFO]   copying %s\n", filepath.Base(currentPart.Name()))
+			}
+			if _, err := io.Copy(w, io.NewSectionReader(span.file, span.offset, span.size)); err != nil {
+				return fmt.Errorf("copying tensor %s: %w", span.info.Name, err)
+			}
+			if _, err := w.Write(make([]byte, ggufAlign(span.size, alignment)-span.size)); err != nil {
+				return fmt.Errorf("padding tensor %s: %w", span.info.Name, err)
+			}
+		}
+		return bw.Flush()
+	}()
+	if closeErr := out.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		os.Remove(tmpPath)
+		return "", writeErr
+	}
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return outPath, nil
+}
+
+// mergeValidatedSplits merges every series that passed validation and reports
+// whether all of them could be merged.
+func mergeValidatedSplits(downloadDir string, seriesList []*ggufSplitSeries) bool {
+	allMerged := true
+	for _, series := range seriesList {
+		if len(series.Problems) > 0 {
+			fmt.Fprintf(os.Stderr, "[WARN] Not merging %s: the split is incomplete or inconsistent.\n", series.BaseName)
+			allMerged = false
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "[INFO] Merging %d parts of %s...\n", series.Total, series.BaseName)
+		outPath, err := mergeGGUFSplit(downloadDir, series)
+		if err != nil {
+			appLogger.Printf("[GGUFSplit] Merging %s failed: %v", series.BaseName, err)
+			fmt.Fprintf(os.Stderr, "[ERROR] Merging %s failed: %v\n", series.BaseName, err)
+			allMerged = false
+			continue
+		}
+		md, err := readGGUFFile(outPath, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] The merged file %s cannot be read back: %v\n", outPath, err)
+			allMerged = false
+			continue
+		}
+		appLogger.Printf("[GGUFSplit] Merged %s: %d tensors.", outPath, md.TensorCount)
+		fmt.Fprintf(os.Stderr, "[INFO] Merged into %s (%d tensors, the parts are kept).\n", outPath, md.TensorCount)
+	}
+	return allMerged
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io" // Added import for io.ReadAll
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -15,10 +17,14 @@ import (
 type HFFile struct {
 	URL      string
 	Filename string // Original filename from the repository (Sibling.Rfilename)
+	Size     int64  // Size from the tree API, 0 if unknown
+	SHA256   string // LFS object sha256, empty for regular git files or if unknown
+	BlobID   string // Git blob id of the file (for LFS files this is the pointer's blob)
 }
 
 // --- Structs for Hugging Face API ---
 type RepoInfo struct {
+	SHA      string    `json:"sha"` // Commit the listing was taken from
 	Siblings []Sibling `json:"siblings"`
 }
 
@@ -26,105 +32,301 @@ type Sibling struct {
 	Rfilename string `json:"rfilename"`
 }
 
-// --- Hugging Face URL Fetching Logic ---
-func fetchHuggingFaceURLs(repoInput string, hfToken string) ([]HFFile, error) {
-	appLogger.Printf("[HF] Processing Hugging Face repository input: %s", repoInput)
+// HFTreeEntry is one entry of the /api/models/{repo}/tree/{revision} listing.
+type HFTreeEntry struct {
+	Type string `json:"type"` // "file" or "directory"
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+	Path string `json:"path"`
+	LFS  *struct {
+		Oid         string `json:"oid"` // sha256 of the actual file content
+		Size        int64  `json:"size"`
+		PointerSize int64  `json:"pointerSize"`
+	} `json:"lfs,omitempty"`
+}
 
-	var repoID string
+// linkNextRegex extracts the next page URL from a Link header, e.g. `<https://...>; rel="next"`.
+var linkNextRegex = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
 
-	if strings.HasPrefix(repoInput, "http://") || strings.HasPrefix(repoInput, "https://") {
-		parsedInputURL, err := url.Parse(repoInput)
+// The link may be relative, or point at huggingface.co when served through a mirror.
+func nextPageURL(resp *http.Response) string {
+	matches := linkNextRegex.FindStringSubmatch(resp.Header.Get("Link"))
+	if len(matches) != 2 {
+		return ""
+	}
+	next, err := url.Parse(matches[1])
+	if err != nil {
+		return ""
+	}
+	if resp.Request != nil {
+		next = resp.Request.URL.ResolveReference(next)
+	}
+	return withHFEndpoint(next.String())
+}
+
+// fetchHuggingFaceTree lists every file of a repository revision, following pagination.
+func fetchHuggingFaceTree(repo hfRepo, revision string, hfToken string) ([]HFTreeEntry, error) {
+	pageURL := repo.apiURL("tree/"+url.PathEscape(revision)) + "?recursive=true"
+	httpClient := http.Client{Timeout: 60 * time.Second, CheckRedirect: hfCheckRedirect}
+	var entries []HFTreeEntry
+	_, showCount := terminalWidth(os.Stderr) // Large repos take many pages
+	countShown := false
+
+	for pageURL != "" {
+		appLogger.Printf("[HF] Fetching tree page: %s", pageURL)
+		req, err := http.NewRequest("GET", pageURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request for tree API '%s': %w", pageURL, err)
+		}
+		if hfToken != "" {
+			req.Header.Set("Authorization", "Bearer "+hfToken)
+		}
+		resp, err := httpClient.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing repository URL '%s': %w", repoInput, err)
+			return nil, fmt.Errorf("error fetching tree from '%s': %w", pageURL, err)
 		}
-		if parsedInputURL.Host != "huggingface.co" {
-			return nil, fmt.Errorf("expected a huggingface.co URL, got: %s", parsedInputURL.Host)
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if msg := hfAccessMessage(repo, resp.StatusCode, hfToken != ""); msg != "" {
+				return nil, &statusError{StatusCode: resp.StatusCode, err: fmt.Errorf("%s", msg)}
+			}
+			return nil, fmt.Errorf("tree API request to %s failed with status %s. Detail: %s", pageURL, resp.Status, strings.TrimSpace(string(bodyBytes)))
 		}
-		repoPath := strings.TrimPrefix(parsedInputURL.Path, "/")
-		pathParts := strings.Split(repoPath, "/")
-		if len(pathParts) < 2 {
-			return nil, fmt.Errorf("invalid repository path in URL. Expected 'owner/repo_name', got: '%s'", repoPath)
+		var page []HFTreeEntry
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		next := nextPageURL(resp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding tree JSON response: %w", err)
 		}
-		repoID = fmt.Sprintf("%s/%s", pathParts[0], pathParts[1])
-	} else if strings.Count(repoInput, "/") == 1 {
-		parts := strings.Split(repoInput, "/")
-		if len(parts[0]) > 0 && len(parts[1]) > 0 {
-			repoID = repoInput
-		} else {
-			return nil, fmt.Errorf("invalid repository ID format. Expected 'owner/repo_name', got: '%s'", repoInput)
+		entries = append(entries, page...)
+		if showCount && next != "" {
+			fmt.Fprintf(os.Stderr, "\r[INFO] Listed %d entries...", len(entries))
+			countShown = true
 		}
-	} else {
-		return nil, fmt.Errorf("invalid -hf input '%s'. Expected 'owner/repo_name' or full https://huggingface.co/owner/repo_name URL", repoInput)
+		pageURL = next
 	}
+	if countShown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	appLogger.Printf("[HF] Tree listing for %s@%s returned %d entries.", repo, revision, len(entries))
+	return entries, nil
+}
 
-	branch := "main" // Assuming "main" branch, could be parameterized if needed
+// defaultHFRevision is used when -revision is not given.
+const defaultHFRevision = "main"
 
-	appLogger.Printf("[HF] Determined RepoID: %s, Branch for download URLs: %s", repoID, branch)
-	fmt.Fprintf(os.Stderr, "[INFO] Fetching file list for repository: %s (branch: %s)...\n", repoID, branch)
+// Repository types, the values of -repo-type.
+const (
+	hfRepoTypeModel   = "model"
+	hfRepoTypeDataset = "dataset"
+	hfRepoTypeSpace   = "space"
+)
 
-	apiURL := fmt.Sprintf("https://huggingface.co/api/models/%s", repoID)
-	appLogger.Printf("[HF] Using API endpoint for repo files: %s", apiURL)
+// hfRepo identifies a Hugging Face repository.
+type hfRepo struct {
+	ID   string // owner/repo
+	Type string // hfRepoTypeModel, hfRepoTypeDataset or hfRepoTypeSpace
+}
 
-	httpClient := http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request for API '%s': %w", apiURL, err)
+// String returns the repository as it appears in Hub URLs, e.g.
+// "owner/repo" for a model and "datasets/owner/repo" for a dataset.
+func (r hfRepo) String() string {
+	if r.Type == hfRepoTypeModel {
+		return r.ID
 	}
+	return r.Type + "s/" + r.ID
+}
 
-	if hfToken != "" {
-		req.Header.Set("Authorization", "Bearer "+hfToken)
-		appLogger.Printf("[HF] Using Hugging Face token for API request to %s", apiURL)
+// apiURL returns the URL of an API endpoint of the repository, e.g.
+// https://huggingface.co/api/datasets/owner/repo/tree/main for "tree/main",
+// or of the repository info itself for "".
+func (r hfRepo) apiURL(endpoint string) string {
+	if endpoint == "" {
+		return hfEndpointURL(fmt.Sprintf("api/%ss/%s", r.Type, r.ID))
 	}
+	return hfEndpointURL(fmt.Sprintf("api/%ss/%s/%s", r.Type, r.ID, endpoint))
+}
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching data from API '%s': %w", apiURL, err)
+// resolveURL returns the download URL of a file at a revision.
+func (r hfRepo) resolveURL(revision string, filename string) string {
+	filenameParts := strings.Split(filename, "/")
+	for i, p := range filenameParts {
+		filenameParts[i] = url.PathEscape(p)
 	}
-	defer resp.Body.Close()
+	return hfEndpointURL(fmt.Sprintf("%s/resolve/%s/%s?download=true", r, url.PathEscape(revision), strings.Join(filenameParts, "/")))
+}
+
+func isValidHFRepoType(repoType string) bool {
+	switch repoType {
+	case hfRepoTypeModel, hfRepoTypeDataset, hfRepoTypeSpace:
+		return true
+	}
+	return false
+}
 
-	if resp.StatusCode != http.StatusOK {
-		// Try to read body for more error info from HF API
-		bodyBytes, readErr := io.ReadAll(resp.Body)
-		var errorDetail string
-		if readErr == nil && len(bodyBytes) > 0 {
-			errorDetail = string(bodyBytes)
-			appLogger.Printf("[HF] API error response body: %s", errorDetail)
+// parseHuggingFaceRepo accepts 'owner/repo', 'datasets/owner/repo', 'spaces/owner/repo'
+// or the https://huggingface.co/ (or -hf-endpoint) URL of any of these, e.g. one pasted
+// from a file page.
+// repoType is the -repo-type value; if empty the type comes from the input and
+// defaults to model. A type given both ways has to agree.
+func parseHuggingFaceRepo(repoInput string, repoType string) (hfRepo, error) {
+	if repoType != "" && !isValidHFRepoType(repoType) {
+		return hfRepo{}, fmt.Errorf("invalid repository type '%s'. Expected model, dataset or space", repoType)
+	}
+	repoPath := repoInput
+	if strings.HasPrefix(repoInput, "http://") || strings.HasPrefix(repoInput, "https://") {
+		parsedInputURL, err := url.Parse(repoInput)
+		if err != nil {
+			return hfRepo{}, fmt.Errorf("error parsing repository URL '%s': %w", repoInput, err)
+		}
+		if !isHFHost(parsedInputURL.Host) {
+			return hfRepo{}, fmt.Errorf("expected a huggingface.co or %s URL, got: %s", hfEndpoint, parsedInputURL.Host)
 		}
-		return nil, fmt.Errorf("API request to %s failed with status %s. Detail: %s", apiURL, resp.Status, errorDetail)
+		repoPath = parsedInputURL.Path
+		if endpointURL, err := url.Parse(hfEndpoint); err == nil && strings.EqualFold(endpointURL.Host, parsedInputURL.Host) {
+			repoPath = strings.TrimPrefix(repoPath, strings.TrimRight(endpointURL.Path, "/")) // Endpoint may live under a path
+		}
+	}
+	pathParts := strings.Split(strings.Trim(repoPath, "/"), "/")
+
+	detectedType := ""
+	switch pathParts[0] {
+	case "datasets":
+		detectedType = hfRepoTypeDataset
+	case "spaces":
+		detectedType = hfRepoTypeSpace
+	case "models":
+		detectedType = hfRepoTypeModel
+	}
+	if detectedType != "" {
+		pathParts = pathParts[1:]
+	}
+	isURL := repoPath != repoInput
+	if len(pathParts) < 2 || (!isURL && len(pathParts) != 2) || pathParts[0] == "" || pathParts[1] == "" {
+		return hfRepo{}, fmt.Errorf("invalid -hf input '%s'. Expected 'owner/repo_name', 'datasets/owner/repo_name', 'spaces/owner/repo_name' or a https://huggingface.co/ URL of one", repoInput)
+	}
+
+	switch {
+	case repoType != "" && detectedType != "" && repoType != detectedType:
+		return hfRepo{}, fmt.Errorf("-repo-type %s does not match '%s', which is a %s repository", repoType, repoInput, detectedType)
+	case repoType == "" && detectedType == "":
+		repoType = hfRepoTypeModel
+	case repoType == "":
+		repoType = detectedType
 	}
+	return hfRepo{ID: pathParts[0] + "/" + pathParts[1], Type: repoType}, nil
+}
+
+// --- Hugging Face URL Fetching Logic ---
 
+// fetchHFRevision gets the revision endpoint of repo. expand (e.g. "sha") limits the
+// response to those fields, leaving out the siblings list that runs to megabytes for
+// datasets with thousands of files; empty asks for everything. A server that rejects
+// expand gets the full request instead.
+func fetchHFRevision(repo hfRepo, revision string, hfToken string, expand string) (*RepoInfo, error) {
+	apiURL := repo.apiURL("revision/" + url.PathEscape(revision))
+	if expand != "" {
+		apiURL += "?" + url.Values{"expand[]": {expand}}.Encode()
+	}
+	appLogger.Printf("[HF] Using API endpoint for the revision: %s", apiURL)
+	status, body, err := hfAPIGet(apiURL, hfToken)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusBadRequest && expand != "" {
+		appLogger.Printf("[HF] %s rejected expand=%s, requesting the full revision info.", apiURL, expand)
+		return fetchHFRevision(repo, revision, hfToken, "")
+	}
+	if status != http.StatusOK {
+		appLogger.Printf("[HF] API error response body: %s", body)
+		if msg := hfAccessMessage(repo, status, hfToken != ""); msg != "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, fmt.Errorf("API request to %s failed with status %d. Detail: %s", apiURL, status, body)
+	}
 	var repoData RepoInfo
-	if err := json.NewDecoder(resp.Body).Decode(&repoData); err != nil {
+	if err := json.Unmarshal(body, &repoData); err != nil {
 		return nil, fmt.Errorf("error decoding JSON response: %w", err)
 	}
+	return &repoData, nil
+}
+
+// fetchHuggingFaceURLs lists the files of a repository at revision (a branch, tag,
+// PR ref like refs/pr/12, or commit sha). The revision is resolved to its commit sha,
+// which is returned and used in every download URL, so all files come from the same
+// commit even if the branch moves while downloading.
+//
+// Files are listed with the paginated tree API, which also carries sizes and LFS
+// hashes. The commit is resolved by asking the revision endpoint for the sha alone;
+// its siblings are only fetched as a fallback, as dataset repos can hold many
+// thousands of files.
+func fetchHuggingFaceURLs(repo hfRepo, revision string, hfToken string) ([]HFFile, string, error) {
+	if revision == "" {
+		revision = defaultHFRevision
+	}
+
+	appLogger.Printf("[HF] Determined Repo: %s (%s), Revision: %s", repo.ID, repo.Type, revision)
+	fmt.Fprintf(os.Stderr, "[INFO] Fetching file list for %s repository: %s (revision: %s)...\n", repo.Type, repo.ID, revision)
 
-	if len(repoData.Siblings) == 0 {
-		appLogger.Printf("[HF] No files found in repository %s via API.", repoID)
-		fmt.Fprintf(os.Stderr, "[INFO] No files found in repository %s. The API might have changed, the repo is empty, or access is restricted (check --token and HF_TOKEN for private/gated repos).\n", repoID)
-		return []HFFile{}, nil
+	repoData, err := fetchHFRevision(repo, revision, hfToken, "sha")
+	if err != nil {
+		return nil, "", err
 	}
 
-	appLogger.Printf("[HF] Found %d file entries in repository %s.", len(repoData.Siblings), repoID)
-	fmt.Fprintf(os.Stderr, "[INFO] Found %d file entries. Generating download info...\n", len(repoData.Siblings))
+	commitSHA := repoData.SHA
+	if commitSHA == "" {
+		// Should not happen with the revision endpoint; fall back to the unresolved name.
+		appLogger.Printf("[HF] API did not return a commit sha for %s@%s, using the revision name in URLs.", repo, revision)
+		commitSHA = revision
+	} else if commitSHA != revision {
+		fmt.Fprintf(os.Stderr, "[INFO] Resolved revision '%s' to commit %s.\n", revision, commitSHA)
+	}
 
 	var hfFiles []HFFile
-	for _, sibling := range repoData.Siblings {
-		if sibling.Rfilename == "" {
-			appLogger.Printf("[HF] Skipping sibling with empty rfilename.")
-			continue
+	treeEntries, treeErr := fetchHuggingFaceTree(repo, commitSHA, hfToken)
+	var treeStatusErr *statusError
+	if errors.As(treeErr, &treeStatusErr) && treeStatusErr.StatusCode != http.StatusNotFound {
+		return nil, "", treeErr // Access denied; the files could not be downloaded either
+	}
+	if treeErr != nil {
+		appLogger.Printf("[HF] Could not list files of %s with the tree API: %v", repo, treeErr)
+		fmt.Fprintf(os.Stderr, "[WARN] Could not fetch the file listing with checksums for %s; using the basic listing, downloads will not be verified.\n", repo)
+		fullData, err := fetchHFRevision(repo, commitSHA, hfToken, "")
+		if err != nil {
+			return nil, "", err
 		}
-
-		rfilenameParts := strings.Split(sibling.Rfilename, "/")
-		escapedRfilenameParts := make([]string, len(rfilenameParts))
-		for i, p := range rfilenameParts {
-			escapedRfilenameParts[i] = url.PathEscape(p)
+		for _, sibling := range fullData.Siblings {
+			if sibling.Rfilename == "" {
+				appLogger.Printf("[HF] Skipping sibling with empty rfilename.")
+				continue
+			}
+			hfFiles = append(hfFiles, HFFile{URL: repo.resolveURL(commitSHA, sibling.Rfilename), Filename: sibling.Rfilename})
 		}
-		safeRfilenamePath := strings.Join(escapedRfilenameParts, "/")
+	} else {
+		for _, entry := range treeEntries {
+			if entry.Type != "file" || entry.Path == "" {
+				continue
+			}
+			hfFile := HFFile{URL: repo.resolveURL(commitSHA, entry.Path), Filename: entry.Path, Size: entry.Size, BlobID: entry.Oid}
+			if entry.LFS != nil {
+				hfFile.SHA256 = entry.LFS.Oid
+				hfFile.Size = entry.LFS.Size
+			}
+			hfFiles = append(hfFiles, hfFile)
+		}
+	}
 
-		dlURL := fmt.Sprintf("https://huggingface.co/%s/resolve/%s/%s?download=true", repoID, branch, safeRfilenamePath)
-		hfFiles = append(hfFiles, HFFile{URL: dlURL, Filename: sibling.Rfilename})
-		appLogger.Printf("[HF] Generated download info: URL: %s for rfilename: %s", dlURL, sibling.Rfilename)
+	if len(hfFiles) == 0 {
+		appLogger.Printf("[HF] No files found in repository %s via API.", repo)
+		fmt.Fprintf(os.Stderr, "[INFO] No files found in repository %s. The API might have changed, the repo is empty, or access is restricted (for private/gated repos set HF_TOKEN or run 'dl auth login').\n", repo)
+		return []HFFile{}, commitSHA, nil
+	}
+	for _, hfFile := range hfFiles {
+		appLogger.Printf("[HF] Generated download info: URL: %s for rfilename: %s", hfFile.URL, hfFile.Filename)
 	}
-	fmt.Fprintf(os.Stderr, "[INFO] Successfully generated info for %d files from Hugging Face repository.\n", len(hfFiles))
-	return hfFiles, nil
+	appLogger.Printf("[HF] Found %d files in repository %s at commit %s.", len(hfFiles), repo, commitSHA)
+	fmt.Fprintf(os.Stderr, "[INFO] Found %d files in %s.\n", len(hfFiles), repo)
+	return hfFiles, commitSHA, nil
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hfAccessMessage explains a 401, 403 or 404 from the Hub for repo in terms of what
+// the user has to do, or returns "" for any other status.
+func hfAccessMessage(repo hfRepo, status int, hasToken bool) string {
+	repoURL := hfEndpointURL(repo.String())
+	switch status {
+	case http.StatusUnauthorized:
+		if !hasToken {
+			return fmt.Sprintf("%s needs a Hugging Face token (401): it is private or gated, or does not exist. Log in with 'dl auth login' (or set HF_TOKEN) and try again", repo)
+		}
+		return fmt.Sprintf("the token was not accepted for %s (401): it is invalid or expired, or the repo is private and your account has no access. Check it with 'dl auth whoami'", repo)
+	case http.StatusForbidden:
+		if !hasToken {
+			return fmt.Sprintf("%s is gated (403). Accept its terms at %s, then log in with 'dl auth login' (or set HF_TOKEN) and try again", repo, repoURL)
+		}
+		return fmt.Sprintf("your account has no access to the gated repo %s (403). Accept its terms at %s (approval may take a while for manually gated repos), then try again", repo, repoURL)
+	case http.StatusNotFound:
+		hint := "check the repository name and -revision"
+		if repo.Type == hfRepoTypeModel {
+			hint += ", or use -repo-type dataset|space for datasets and Spaces"
+		}
+		return fmt.Sprintf("%s or the requested revision/file was not found (404): %s", repo, hint)
+	}
+	return ""
+}
+
+// hfIsGated reports whether the gated field of the repo info is set; it is false
+// for open repos and "auto" or "manual" for gated ones.
+func hfIsGated(gated interface{}) (bool, string) {
+	switch v := gated.(type) {
+	case bool:
+		return v, ""
+	case string:
+		return v != "" && v != "false", v
+	}
+	return false, ""
+}
+
+// checkHFRepoAccess looks up the repository's private/gated status before anything
+// is listed or downloaded, and for gated repos asks the Hub whether the token has
+// been granted access, so a missing approval fails with an actionable message
+// instead of one 403 per file.
+func checkHFRepoAccess(repo hfRepo, hfToken string) error {
+	// Only the fields needed here; the full repo info carries the list of every file.
+	expand := url.Values{"expand[]": {"private", "gated", "disabled"}}
+	if repo.Type == hfRepoTypeSpace {
+		expand = url.Values{"expand[]": {"private", "disabled"}} // Spaces cannot be gated
+	}
+	apiURL := repo.apiURL("") + "?" + expand.Encode()
+	appLogger.Printf("[HFAccess] Checking access to %s via %s", repo, apiURL)
+	status, body, err := hfAPIGet(apiURL, hfToken)
+	if err == nil && status == http.StatusBadRequest {
+		appLogger.Printf("[HFAccess] %s rejected expand, requesting the full repo info.", apiURL)
+		status, body, err = hfAPIGet(repo.apiURL(""), hfToken)
+	}
+	if err != nil {
+		return err
+	}
+	if msg := hfAccessMessage(repo, status, hfToken != ""); msg != "" {
+		return fmt.Errorf("%s", msg)
+	}
+	if status != http.StatusOK {
+		// Leave it to the listing to fail; e.g. a mirror may not implement this endpoint.
+		appLogger.Printf("[HFAccess] Repo info for %s returned %d, skipping the access check.", repo, status)
+		return nil
+	}
+
+	var info HFApiModelInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		appLogger.Printf("[HFAccess] Could not decode repo info of %s: %v", repo, err)
+		return nil
+	}
+	if info.Disabled {
+		return fmt.Errorf("%s has been disabled on the Hub and cannot be downloaded", repo)
+	}
+	if info.Private {
+		appLogger.Printf("[HFAccess] %s is private and the token has access.", repo)
+		fmt.Fprintf(os.Stderr, "[INFO] %s is a private repository.\n", repo)
+	}
+	gated, mode := hfIsGated(info.Gated)
+	if !gated {
+		return nil
+	}
+	if mode == "" {
+		mode = "gated"
+	}
+	fmt.Fprintf(os.Stderr, "[INFO] %s is a gated repository (approval: %s).\n", repo, mode)
+	if hfToken == "" {
+		return fmt.Errorf("%s", hfAccessMessage(repo, http.StatusForbidden, false))
+	}
+
+	status, _, err = hfAPIGet(repo.apiURL("auth-check"), hfToken)
+	if err != nil {
+		return err
+	}
+	if msg := hfAccessMessage(repo, status, true); msg != "" && status != http.StatusNotFound {
+		return fmt.Errorf("%s", msg)
+	}
+	if status != http.StatusOK {
+		appLogger.Printf("[HFAccess] auth-check for %s returned %d, assuming access.", repo, status)
+	} else {
+		appLogger.Printf("[HFAccess] Access to gated repo %s granted.", repo)
+	}
+	return nil
+}
+
+// hfAPIGet performs a GET against the Hub API and returns the status and body.
+func hfAPIGet(apiURL string, hfToken string) (int, []byte, error) {
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error creating request for '%s': %w", apiURL, err)
+	}
+	if hfToken != "" {
+		req.Header.Set("Authorization", "Bearer "+hfToken)
+	}
+	client := http.Client{Timeout: 30 * time.Second, CheckRedirect: hfCheckRedirect}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error fetching '%s': %w", apiURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("error reading response from '%s': %w", apiURL, err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// hfRepoFromURL recovers the repository of a download URL built by resolveURL (or
+// found in the model registry), e.g. https://huggingface.co/owner/repo/resolve/main/x.
+func hfRepoFromURL(rawURL string) (hfRepo, bool) {
+	if !isHFURL(rawURL) {
+		return hfRepo{}, false
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return hfRepo{}, false
+	}
+	urlPath := parsed.Path
+	if endpointURL, err := url.Parse(hfEndpoint); err == nil && strings.EqualFold(endpointURL.Host, parsed.Host) {
+		urlPath = strings.TrimPrefix(urlPath, strings.TrimRight(endpointURL.Path, "/"))
+	}
+	parts := strings.Split(strings.Trim(urlPath, "/"), "/")
+	repoType := hfRepoTypeModel
+	if len(parts) > 0 && (parts[0] == "datasets" || parts[0] == "spaces") {
+		repoType = strings.TrimSuffix(parts[0], "s")
+		parts = parts[1:]
+	}
+	if len(parts) < 3 || parts[2] != "resolve" {
+		return hfRepo{}, false
+	}
+	return hfRepo{ID: parts[0] + "/" + parts[1], Type: repoType}, true
+}
+
+// reportHFAccessFailures prints one actionable message per repository and status
+// for downloads that failed with 401, 403 or 404, which the progress display can
+// only show as a truncated "HTTP 403".
+func reportHFAccessFailures(pws []*ProgressWriter, hfToken string) {
+	type failureKey struct {
+		repo   hfRepo
+		status int
+	}
+	counts := make(map[failureKey]int)
+	var keys []failureKey
+	for _, pw := range pws {
+		if pw == nil {
+			continue
+		}
+		pw.mu.Lock()
+		status := pw.HTTPStatus
+		pw.mu.Unlock()
+		if status != http.StatusUnauthorized && status != http.StatusForbidden && status != http.StatusNotFound {
+			continue
+		}
+		repo, ok := hfRepoFromURL(pw.URL)
+		if !ok {
+			continue
+		}
+		key := failureKey{repo, status}
+		if counts[key] == 0 {
+			keys = append(keys, key)
+		}
+		counts[key]++
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].repo != keys[j].repo {
+			return keys[i].repo.String() < keys[j].repo.String()
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(os.Stderr, "[ERROR] %d file(s) failed: %s.\n", counts[key], hfAccessMessage(key.repo, key.status, hfToken != ""))
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// hfCacheMode makes -hf downloads go into the Hugging Face hub cache instead of
+// downloads/<owner>_<repo>, set by main.go via -hf-cache.
+var hfCacheMode bool
+
+// hfBlobNameRegex matches the names blobs are stored under: the git blob sha1 of a
+// regular file or the sha256 of an LFS file.
+var hfBlobNameRegex = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// hfHubCacheDir returns the hub cache directory the way huggingface_hub resolves it:
+// HF_HUB_CACHE, else <HF home>/hub.
+func hfHubCacheDir() (string, error) {
+	for _, env := range []string{"HF_HUB_CACHE", "HUGGINGFACE_HUB_CACHE"} {
+		if dir := os.Getenv(env); dir != "" {
+			return dir, nil
+		}
+	}
+	hfHome, err := hfHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hfHome, "hub"), nil
+}
+
+// hfCacheRepoFolder is the folder name of a repository in the hub cache,
+// e.g. "models--Qwen--Qwen3-8B-GGUF" or "datasets--HuggingFaceFW--fineweb".
+func hfCacheRepoFolder(repo hfRepo) string {
+	return repo.Type + "s--" + strings.ReplaceAll(repo.ID, "/", "--")
+}
+
+// hfCacheBlobName is the name a file's content is stored under in blobs/, or ""
+// if the listing did not provide its hash.
+func hfCacheBlobName(file HFFile) string {
+	name := strings.ToLower(file.SHA256)
+	if name == "" {
+		name = strings.ToLower(file.BlobID)
+	}
+	if !hfBlobNameRegex.MatchString(name) {
+		return ""
+	}
+	return name
+}
+
+// hfCacheRepo is one repository commit in the hub cache:
+//
+//	<type>s--owner--repo/blobs/<sha256 or git blob id>   file contents
+//	<type>s--owner--repo/snapshots/<commit>/<path>       symlinks into blobs
+//	<type>s--owner--repo/refs/<revision>                 commit sha of a branch or tag
+type hfCacheRepo struct {
+	dir    string
+	commit string
+	blobs  map[string]string // Download URL -> blob name, for files downloaded this run
+}
+
+func openHFCacheRepo(repo hfRepo, commit string) (*hfCacheRepo, error) {
+	cacheDir, err := hfHubCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	if repo.ID == "" || commit == "" {
+		return nil, fmt.Errorf("-hf-cache needs an owner/repo and a resolved commit")
+	}
+	cacheRepo := &hfCacheRepo{dir: filepath.Join(cacheDir, hfCacheRepoFolder(repo)), commit: commit, blobs: make(map[string]string)}
+	for _, sub := range []string{"blobs", "refs", filepath.Join("snapshots", commit)} {
+		if err := os.MkdirAll(filepath.Join(cacheRepo.dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	appLogger.Printf("[HFCache] Using %s at commit %s.", cacheRepo.dir, commit)
+	return cacheRepo, nil
+}
+
+func (c *hfCacheRepo) snapshotDir() string { return filepath.Join(c.dir, "snapshots", c.commit) }
+
+func (c *hfCacheRepo) blobPath(name string) string { return filepath.Join(c.dir, "blobs", name) }
+
+// writeRef records which commit a branch or tag points to, as huggingface_hub does.
+// Nothing is recorded when the revision is the commit sha itself.
+func (c *hfCacheRepo) writeRef(revision string) error {
+	if revision == "" || strings.EqualFold(revision, c.commit) {
+		return nil
+	}
+	refPath := filepath.Join(c.dir, "refs", filepath.FromSlash(revision))
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(refPath, []byte(c.commit), 0644)
+}
+
+// linkExisting links every file whose blob is already in the cache into the
+// snapshot and returns the files that still have to be downloaded.
+func (c *hfCacheRepo) linkExisting(files []HFFile) (toDownload []HFFile, reused int) {
+	missingHash := 0
+	for _, file := range files {
+		blob := hfCacheBlobName(file)
+		if blob == "" {
+			missingHash++
+			toDownload = append(toDownload, file)
+			continue
+		}
+		info, err := os.Stat(c.blobPath(blob))
+		if err != nil || (file.Size > 0 && info.Size() != file.Size) {
+			c.blobs[file.URL] = blob
+			toDownload = append(toDownload, file)
+			continue
+		}
+		if err := c.linkSnapshotFile(file.Filename, blob); err != nil {
+			appLogger.Printf("[HFCache] Could not link cached blob %s for %s: %v. Downloading it.", blob, file.Filename, err)
+			c.blobs[file.URL] = blob
+			toDownload = append(toDownload, file)
+			continue
+		}
+		appLogger.Printf("[HFCache] %s is already cached as blob %s.", file.Filename, blob)
+		reused++
+	}
+	if missingHash > 0 {
+		fmt.Fprintf(os.Stderr, "[WARN] %d file(s) have no known hash and are stored in the snapshot directly instead of as blobs.\n", missingHash)
+	}
+	return toDownload, reused
+}
+
+// finalize moves every file downloaded into the snapshot to its blob and replaces
+// it with a link, so later downloads of other revisions can reuse the content.
+func (c *hfCacheRepo) finalize(pws []*ProgressWriter) {
+	for _, pw := range pws {
+		if pw == nil {
+			continue
+		}
+		pw.mu.Lock()
+		ok := pw.IsFinished && pw.ErrorMsg == ""
+		pw.mu.Unlock()
+		blob := c.blobs[pw.URL]
+		if !ok || blob == "" {
+			continue
+		}
+		filePath := filepath.Join(c.snapshotDir(), pw.ActualFileName)
+		info, err := os.Lstat(filePath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue // Not downloaded, or already a link into blobs
+		}
+		blobPath := c.blobPath(blob)
+		if _, statErr := os.Stat(blobPath); statErr == nil {
+			os.Remove(filePath) // Same content already stored, e.g. under another path
+		} else if err := os.Rename(filePath, blobPath); err != nil {
+			appLogger.Printf("[HFCache] Could not move %s to %s: %v", filePath, blobPath, err)
+			fmt.Fprintf(os.Stderr, "[WARN] Could not move '%s' into the cache blobs: %v\n", pw.ActualFileName, err)
+			continue
+		}
+		if err := c.linkSnapshotFile(pw.ActualFileName, blob); err != nil {
+			appLogger.Printf("[HFCache] Could not link %s to blob %s: %v", pw.ActualFileName, blob, err)
+			fmt.Fprintf(os.Stderr, "[WARN] Could not add '%s' to the snapshot: %v\n", pw.ActualFileName, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[INFO] Snapshot: %s\n", c.snapshotDir())
+}
+
+// linkSnapshotFile points snapshots/<commit>/<relPath> at a blob with a relative
+// symlink. Where symlinks are unavailable (e.g. Windows without developer mode)
+// it falls back to a hard link, then to a copy.
+func (c *hfCacheRepo) linkSnapshotFile(relPath string, blob string) error {
+	linkPath := filepath.Join(c.snapshotDir(), generateActualFilename("", relPath))
+	blobPath := c.blobPath(blob)
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(linkPath); err == nil {
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, _ := filepath.EvalSymlinks(linkPath); target != "" {
+				if resolvedBlob, _ := filepath.EvalSymlinks(blobPath); target == resolvedBlob {
+					return nil
+				}
+			}
+		}
+		if err := os.Remove(linkPath); err != nil {
+			return err
+		}
+	}
+	target, err := filepath.Rel(filepath.Dir(linkPath), blobPath)
+	if err != nil {
+		return err
+	}
+	symlinkErr := os.Symlink(target, linkPath)
+	if symlinkErr == nil {
+		return nil
+	}
+	appLogger.Printf("[HFCache] Symlink %s -> %s failed: %v. Trying a hard link.", linkPath, target, symlinkErr)
+	if err := os.Link(blobPath, linkPath); err == nil {
+		return nil
+	}
+	return copyFile(blobPath, linkPath)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultHFEndpoint is the Hugging Face Hub; HF_ENDPOINT or -hf-endpoint replace it
+// with a mirror or a local server implementing the same API and resolve protocol.
+const defaultHFEndpoint = "https://huggingface.co"
+
+// hfEndpoint is the base URL every Hugging Face API and download URL is built from,
+// set by main.go via setHFEndpoint.
+var hfEndpoint = defaultHFEndpoint
+
+// setHFEndpoint validates and sets hfEndpoint, e.g. "https://hf-mirror.com" or
+// "http://localhost:8080/hf". An empty value keeps the current endpoint.
+func setHFEndpoint(rawEndpoint string) error {
+	rawEndpoint = strings.TrimRight(strings.TrimSpace(rawEndpoint), "/")
+	if rawEndpoint == "" {
+		return nil
+	}
+	parsed, err := url.Parse(rawEndpoint)
+	if err != nil {
+		return fmt.Errorf("invalid Hugging Face endpoint '%s': %w", rawEndpoint, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return fmt.Errorf("invalid Hugging Face endpoint '%s': expected http(s)://host[:port][/path]", rawEndpoint)
+	}
+	hfEndpoint = rawEndpoint
+	if hfEndpoint != defaultHFEndpoint {
+		appLogger.Printf("[HF] Using endpoint %s.", hfEndpoint)
+	}
+	return nil
+}
+
+// hfEndpointURL returns the endpoint joined with a path, e.g. hfEndpointURL("api/models").
+func hfEndpointURL(urlPath string) string {
+	return hfEndpoint + "/" + strings.TrimPrefix(urlPath, "/")
+}
+
+// withHFEndpoint moves a https://huggingface.co/... URL (e.g. from the model
+// registry or a pagination link) to the configured endpoint. Other URLs are
+// returned unchanged.
+func withHFEndpoint(rawURL string) string {
+	if hfEndpoint == defaultHFEndpoint || !strings.HasPrefix(rawURL, defaultHFEndpoint+"/") {
+		return rawURL
+	}
+	return hfEndpoint + strings.TrimPrefix(rawURL, defaultHFEndpoint)
+}
+
+// hfHosts are the hosts of the Hub itself. Its storage CDNs (cdn-lfs.huggingface.co,
+// cas-bridge.xethub.hf.co and the like) are deliberately not listed: they serve
+// signed URLs and must never receive the token.
+var hfHosts = map[string]bool{"huggingface.co": true, "www.huggingface.co": true}
+
+// isHFHost reports whether host (as in URL.Host) is the Hub or the configured
+// endpoint, i.e. a host that may receive the Hugging Face token.
+func isHFHost(host string) bool {
+	if hfHosts[strings.ToLower(host)] {
+		return true
+	}
+	if endpointURL, err := url.Parse(hfEndpoint); err == nil && strings.EqualFold(endpointURL.Host, host) {
+		return true
+	}
+	return false
+}
+
+// isHFURL reports whether rawURL points at the Hub or the configured endpoint.
+func isHFURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return isHFHost(parsed.Host)
+}
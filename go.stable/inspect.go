@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// inspectMaxStringLen is how much of a long string value (e.g. a chat template) the
+// text output shows; --json prints it in full.
+const inspectMaxStringLen = 120
+
+// HandleInspect implements 'dl inspect <file-or-url> [--json] [--tensors]': it parses
+// the GGUF header of a local file or of a remote file via Range requests and prints
+// the metadata.
+func HandleInspect(args []string, hfToken string) bool {
+	inspectFlags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	inspectFlags.SetOutput(os.Stderr)
+	jsonOutput := inspectFlags.Bool("json", false, "Print the metadata as JSON")
+	listTensors := inspectFlags.Bool("tensors", false, "List every tensor with its type and shape")
+	var target string
+	for len(args) > 0 { // Flags may come before or after the file
+		if err := inspectFlags.Parse(args); err != nil {
+			return false
+		}
+		args = inspectFlags.Args()
+		if len(args) > 0 {
+			if target != "" {
+				fmt.Fprintln(os.Stderr, "Error: 'inspect' takes one file or URL.")
+				return false
+			}
+			target, args = args[0], args[1:]
+		}
+	}
+	if target == "" {
+		fmt.Fprintln(os.Stderr, "Error: Missing <file-or-url> for 'inspect'.")
+		return false
+	}
+
+	var md *GGUFMetadata
+	var err error
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		target = withHFEndpoint(target)
+		appLogger.Printf("[Inspect] Reading GGUF header of %s via Range requests.", target)
+		md, err = fetchGGUFMetadata(target, hfToken, 0, true)
+	} else {
+		appLogger.Printf("[Inspect] Reading GGUF header of local file %s.", target)
+		md, err = readGGUFFile(target, true)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s: %v\n", target, err)
+		if md == nil {
+			return false
+		}
+		fmt.Fprintln(os.Stderr, "[WARN] Showing the part of the header that could be read.")
+	}
+
+	if *jsonOutput {
+		output := struct {
+			Source       string `json:"source"`
+			Architecture string `json:"architecture,omitempty"`
+			SizeLabel    string `json:"size_label,omitempty"`
+			FileType     string `json:"file_type,omitempty"`
+			*GGUFMetadata
+		}{target, md.Architecture(), md.SizeLabel(), md.FileType(), md}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false) // Chat templates are full of < and >
+		if err := encoder.Encode(output); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not encode JSON: %v\n", err)
+			return false
+		}
+		return err == nil
+	}
+	printGGUFMetadata(os.Stdout, target, md, *listTensors)
+	return err == nil
+}
+
+func printGGUFMetadata(w io.Writer, source string, md *GGUFMetadata, listTensors bool) {
+	fmt.Fprintf(w, "File:         %s\n", source)
+	fmt.Fprintf(w, "GGUF:         version %d, %d tensors, %d metadata keys\n", md.Version, md.TensorCount, len(md.KV))
+	if summary := md.Summary(); summary != "" {
+		fmt.Fprintf(w, "Model:        %s\n", summary)
+	}
+	if md.ParameterCount > 0 {
+		label := "Parameters:  "
+		if md.Uint("split.count") > 1 {
+			label = "Params (part):"
+		}
+		fmt.Fprintf(w, "%s %s (%d)\n", label, formatParameterCount(md.ParameterCount), md.ParameterCount)
+	}
+	if len(md.Tensors) > 0 {
+		fmt.Fprintf(w, "Tensor types: %s\n", md.tensorTypeCounts())
+	}
+	fmt.Fprintln(w, "Metadata:")
+	for _, kv := range md.KV {
+		fmt.Fprintf(w, "  %s = %s\n", kv.Key, formatGGUFValue(kv))
+	}
+	if listTensors {
+		fmt.Fprintln(w, "Tensors:")
+		for _, tensor := range md.Tensors {
+			fmt.Fprintf(w, "  %-48s %-8s %v\n", tensor.Name, tensor.Type, tensor.Shape)
+		}
+	}
+}
+
+// formatGGUFValue renders a value for the text output, shortening long strings and
+// arrays.
+func formatGGUFValue(kv GGUFKV) string {
+	if kv.Type == "array" {
+		values, _ := kv.Value.([]interface{})
+		var parts []string
+		for _, v := range values {
+			parts = append(parts, formatGGUFScalar(v))
+		}
+		more := ""
+		if kv.ArrayLen > uint64(len(values)) {
+			more = fmt.Sprintf(", ... (%d %s values)", kv.ArrayLen, kv.ArrayType)
+		}
+		return "[" + strings.Join(parts, ", ") + more + "]"
+	}
+	return formatGGUFScalar(kv.Value)
+}
+
+func formatGGUFScalar(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	if utf8.RuneCountInString(s) <= inspectMaxStringLen {
+		return fmt.Sprintf("%q", s)
+	}
+	runes := []rune(s)
+	return fmt.Sprintf("%q... (%d chars, see --json)", string(runes[:inspectMaxStringLen]), len(runes))
+}
+
+// fetchGGUFSelectionMetadata reads the start of the header of every item (the first
+// part of a series carries the model's metadata) so the menu can show what each
+// file is.
+func fetchGGUFSelectionMetadata(items []SelectableGGUFItem, concurrency int, hfToken string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[INFO] Reading GGUF headers of %d file(s)/series...\n", len(items))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := range items {
+		if len(items[i].FilesToDownload) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(item *SelectableGGUFItem) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			file := item.FilesToDownload[0]
+			md, err := fetchGGUFMetadata(file.URL, hfToken, ggufHeaderProbeBytes, false)
+			if err != nil {
+				appLogger.Printf("[SelectMetadata] Could not read the GGUF header of %s: %v", file.Filename, err)
+				return
+			}
+			item.Metadata = md
+		}(&items[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// localModel is one download directory with a manifest, as shown by 'dl list'.
+type localModel struct {
+	Dir        string     `json:"dir"`
+	Alias      string     `json:"alias,omitempty"`
+	Repo       string     `json:"repo,omitempty"`
+	Revision   string     `json:"revision,omitempty"`
+	Commit     string     `json:"commit,omitempty"`
+	Source     string     `json:"source"` // Repo@commit, or the URL(s) of plain downloads
+	Files      int        `json:"files"`
+	Complete   int        `json:"complete"` // Files on disk with a recorded completion
+	DiskUsage  int64      `json:"disk_usage"`
+	LastUpdate *time.Time `json:"last_update,omitempty"` // Latest completion of any file
+	manifest   *DownloadManifest
+}
+
+// localModelRoots are the directories 'dl list' and 'dl rm' search for manifests:
+// downloads/ and the directories of the aliases that set their own.
+func localModelRoots() []string {
+	roots := []string{"downloads"}
+	aliases, err := modelAliases()
+	if err != nil {
+		appLogger.Printf("[Local] Could not read the model registry: %v", err)
+		return roots
+	}
+	for name, alias := range aliases {
+		if alias.Dir != "" {
+			roots = append(roots, alias.downloadDir(name))
+		}
+	}
+	return roots
+}
+
+// findLocalModels reads every manifest below roots. Directories are reported once,
+// sorted by path.
+func findLocalModels(roots []string) []*localModel {
+	seen := make(map[string]bool)
+	var models []*localModel
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(walkPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if walkPath == root && os.IsNotExist(err) {
+					return nil
+				}
+				appLogger.Printf("[Local] Skipping %s: %v", walkPath, err)
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || d.Name() != manifestFileName {
+				return nil
+			}
+			dir := filepath.Dir(walkPath)
+			key := dir
+			if abs, absErr := filepath.Abs(dir); absErr == nil {
+				key = abs
+			}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+			manifest, loadErr := loadManifest(dir)
+			if loadErr != nil {
+				fmt.Fprintf(os.Stderr, "[WARN] Could not read the manifest in '%s': %v\n", dir, loadErr)
+				return nil
+			}
+			models = append(models, newLocalModel(dir, manifest))
+			return nil
+		})
+		if err != nil {
+			appLogger.Printf("[Local] Walking %s: %v", root, err)
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Dir < models[j].Dir })
+	return models
+}
+
+func newLocalModel(dir string, manifest *DownloadManifest) *localModel {
+	model := &localModel{Dir: dir, Alias: manifest.Alias, Repo: manifest.Repo, Revision: manifest.Revision, Commit: manifest.Commit, Files: len(manifest.Files), manifest: manifest}
+	var urls []string
+	for name, entry := range manifest.Files {
+		urls = append(urls, entry.URL)
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if info, err := os.Stat(filePath); err == nil {
+			model.DiskUsage += info.Size()
+			if entry.CompletedAt != nil {
+				model.Complete++
+				if model.LastUpdate == nil || entry.CompletedAt.After(*model.LastUpdate) {
+					model.LastUpdate = entry.CompletedAt
+				}
+			}
+		}
+		if info, err := os.Stat(partFilePath(filePath)); err == nil {
+			model.DiskUsage += info.Size()
+		}
+	}
+	sort.Strings(urls)
+	switch {
+	case manifest.Repo != "" && manifest.Commit != "":
+		model.Source = fmt.Sprintf("%s@%.12s", manifest.Repo, manifest.Commit)
+	case len(urls) == 1:
+		model.Source = urls[0]
+	case len(urls) > 1:
+		model.Source = fmt.Sprintf("%d URLs", len(urls))
+		if first, err := url.Parse(urls[0]); err == nil {
+			sameHost := true
+			for _, other := range urls[1:] {
+				if parsed, err := url.Parse(other); err != nil || parsed.Host != first.Host {
+					sameHost = false
+					break
+				}
+			}
+			if sameHost {
+				model.Source += " from " + first.Host
+			}
+		}
+	}
+	return model
+}
+
+// HandleList handles 'dl list': every local download directory with a manifest, its
+// source, file count, disk usage and when it was last completed.
+func HandleList(args []string) bool {
+	listFlags := flag.NewFlagSet("list", flag.ContinueOnError)
+	listFlags.SetOutput(os.Stderr)
+	jsonOutput := listFlags.Bool("json", false, "Print the local models as JSON")
+	if err := listFlags.Parse(args); err != nil {
+		return false
+	}
+	roots := listFlags.Args()
+	if len(roots) == 0 {
+		roots = localModelRoots()
+	}
+	models := findLocalModels(roots)
+	if *jsonOutput {
+		if models == nil {
+			models = []*localModel{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(models); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not encode JSON: %v\n", err)
+			return false
+		}
+		return true
+	}
+	if len(models) == 0 {
+		fmt.Fprintf(os.Stderr, "[INFO] No downloads with a manifest found in %s.\n", strings.Join(roots, ", "))
+		return true
+	}
+
+	var total int64
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIR\tALIAS\tSOURCE\tFILES\tSIZE\tUPDATED")
+	for _, model := range models {
+		alias := model.Alias
+		if alias == "" {
+			alias = "-"
+		}
+		files := fmt.Sprintf("%d", model.Files)
+		if model.Complete < model.Files { // Some are missing or unfinished
+			files = fmt.Sprintf("%d/%d", model.Complete, model.Files)
+		}
+		updated := "-"
+		if model.LastUpdate != nil {
+			updated = model.LastUpdate.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", model.Dir, alias, model.Source, files, formatBytes(model.DiskUsage), updated)
+		total += model.DiskUsage
+	}
+	tw.Flush()
+	fmt.Printf("\n%d download(s), %s on disk.\n", len(models), formatBytes(total))
+	return true
+}
+
+// HandleRm handles 'dl rm <alias|repo|dir>': it deletes the recorded files of every
+// matching download directory together with their partial downloads and the
+// manifest, then the directories left empty. Files the manifest does not list are
+// kept.
+func HandleRm(args []string) bool {
+	rmFlags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	rmFlags.SetOutput(os.Stderr)
+	assumeYes := rmFlags.Bool("y", false, "Do not ask for confirmation")
+	var target string
+	for len(args) > 0 { // Flags may come before or after the target
+		if err := rmFlags.Parse(args); err != nil {
+			return false
+		}
+		args = rmFlags.Args()
+		if len(args) > 0 {
+			if target != "" {
+				fmt.Fprintln(os.Stderr, "Error: 'rm' takes one alias, repository or directory.")
+				return false
+			}
+			target, args = args[0], args[1:]
+		}
+	}
+	if target == "" {
+		fmt.Fprintln(os.Stderr, "Error: Missing <alias|repo|dir> for 'rm'.")
+		return false
+	}
+
+	roots := localModelRoots()
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		roots = append(roots, target)
+	}
+	var matches []*localModel
+	for _, model := range findLocalModels(roots) {
+		if localModelMatches(model, target) {
+			matches = append(matches, model)
+		}
+	}
+	if len(matches) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: No local download matches '%s'. See 'dl list'.\n", target)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "[INFO] '%s' matches %d download(s):\n", target, len(matches))
+	for _, model := range matches {
+		fmt.Fprintf(os.Stderr, "  %s (%s, %d file(s), %s)\n", model.Dir, model.Source, model.Files, formatBytes(model.DiskUsage))
+	}
+	if !*assumeYes {
+		fmt.Fprint(os.Stderr, "Delete these files and their manifests? [y/N]: ")
+		answer, _ := stdinReader.ReadString('\n')
+		answer = strings.TrimSpace(strings.ToLower(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Fprintln(os.Stderr, "[INFO] Nothing removed.")
+			return true
+		}
+	}
+
+	ok := true
+	for _, model := range matches {
+		if !removeLocalModel(model) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// localModelMatches reports whether target names the model's alias, repository (with
+// or without a datasets/ or spaces/ prefix) or directory.
+func localModelMatches(model *localModel, target string) bool {
+	if model.Alias != "" && model.Alias == target {
+		return true
+	}
+	if model.Repo != "" {
+		if strings.EqualFold(model.Repo, target) {
+			return true
+		}
+		if repo, err := parseHuggingFaceRepo(target, ""); err == nil && strings.EqualFold(model.Repo, repo.String()) {
+			return true
+		}
+	}
+	return filepath.Clean(model.Dir) == filepath.Clean(target)
+}
+
+// removeLocalModel deletes the recorded files of a download directory, then the
+// manifest and the directories left empty.
+func removeLocalModel(model *localModel) bool {
+	ok := true
+	var removed int64
+	for name := range model.manifest.Files {
+		filePath := filepath.Join(model.Dir, filepath.FromSlash(name))
+		partPath := partFilePath(filePath)
+		for _, candidate := range []string{filePath, partPath, resumeRecordPath(partPath)} {
+			info, err := os.Stat(candidate)
+			if err != nil {
+				continue
+			}
+			if err := os.Remove(candidate); err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] Could not remove '%s': %v\n", candidate, err)
+				ok = false
+				continue
+			}
+			removed += info.Size()
+		}
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "[WARN] Keeping the manifest of '%s' since not every file could be removed.\n", model.Dir)
+		return false
+	}
+	if err := os.Remove(manifestPath(model.Dir)); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "[ERROR] Could not remove the manifest of '%s': %v\n", model.Dir, err)
+		return false
+	}
+
+	// Remove the directories left empty, deepest first; a directory that still holds
+	// files the manifest did not list (or another download's manifest) stays.
+	var dirs []string
+	filepath.WalkDir(model.Dir, func(walkPath string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, walkPath)
+		}
+		return nil
+	})
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		os.Remove(dir) // Fails for non-empty directories
+	}
+	appLogger.Printf("[Local] Removed %d file(s) (%s) and the manifest of %s.", len(model.manifest.Files), formatBytes(removed), model.Dir)
+	if _, err := os.Stat(model.Dir); err == nil {
+		fmt.Fprintf(os.Stderr, "[SUCCESS] Removed %s from %s; files not downloaded by dl were kept.\n", formatBytes(removed), model.Dir)
+	} else {
+		fmt.Fprintf(os.Stderr, "[SUCCESS] Removed %s (%s).\n", model.Dir, formatBytes(removed))
+	}
+	return true
+}
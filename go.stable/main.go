@@ -12,8 +12,6 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
-	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,6 +22,9 @@ import (
 type DownloadItem struct {
 	URL               string
 	PreferredFilename string // Optional, from HF's rfilename or similar context. Can include subdirs.
+	ExpectedSHA256    string // Optional, LFS sha256 from the HF tree API, used to verify the download
+	BlobID            string // Optional, git blob id from the HF tree API, recorded in the manifest
+	Replace           bool   // The file on disk is outdated; it is kept until the new one is complete
 }
 
 // For Hugging Face GGUF selection
@@ -45,28 +46,22 @@ type SelectableGGUFItem struct {
 	DisplayName     string   // e.g., "Series: BF16/model (30 parts, 12.34 GB)" or "File: standalone.gguf, 0.01 GB"
 	FilesToDownload []HFFile // All HFFile objects for this selection (URL + Original Filename)
 	IsSeries        bool
-	IsComplete      bool // For series, indicates if all parts were found
+	IsComplete      bool          // For series, indicates if all parts were found
+	QuantTag        string        // e.g. "Q4_K_M", empty if the name carries none
+	Metadata        *GGUFMetadata // Start of the GGUF header of the (first) file, nil if not read
+	TotalSize       int64         // Size of all files, 0 if unknown
+	FitNote         string        // Memory-fit estimate, e.g. "fits in VRAM (needs ~6.1 GB ...)"
+	quantNames      []string
+	fit             int // fitVRAM, fitRAM, ...
 }
 
 // Regex to capture GGUF series: (base_name)-(part_num)-of-(total_parts).gguf
 // It captures: 1: base_name_with_path, 2: part_num, 3: total_parts
 var ggufSeriesRegex = regexp.MustCompile(`^(.*?)-(\d{5})-of-(\d{5})\.gguf$`)
 
-// Predefined model registry
-var modelRegistry = map[string]string{
-	"qwen3-0.6b":    "https://huggingface.co/Qwen/Qwen3-4B-GGUF/resolve/main/Qwen3-4B-Q4_K_M.gguf?download=true",
-	"qwen3-1.7b":    "https://huggingface.co/Qwen/Qwen3-8B-GGUF/resolve/main/Qwen3-8B-Q4_K_M.gguf?download=true",
-	"qwen3-4b":      "https://huggingface.co/Qwen/Qwen3-4B-GGUF/resolve/main/Qwen3-4B-Q4_K_M.gguf?download=true",
-	"qwen3-8b":      "https://huggingface.co/Qwen/Qwen3-8B-GGUF/resolve/main/Qwen3-8B-Q4_K_M.gguf?download=true",
-	"qwen3-16b":     "https://huggingface.co/Qwen/Qwen3-16B-GGUF/resolve/main/Qwen3-16B-Q4_K_M.gguf?download=true",
-	"qwen3-32b":     "https://huggingface.co/Qwen/Qwen3-32B-GGUF/resolve/main/Qwen3-32B-Q4_K_M.gguf?download=true",
-	"qwen3-30b-moe": "https://huggingface.co/Qwen/Qwen3-16B-GGUF/resolve/main/Qwen3-16B-Q4_K_M.gguf?download=true",
-	"gemma3-27b":    "https://huggingface.co/unsloth/gemma-3-27b-it-GGUF/resolve/main/gemma-3-27b-it-Q4_0.gguf?download=true",
-}
-
 // Package-level variables for global access (e.g., by signal handlers, main defer)
 var manager *ProgressManager      // Initialized only if downloads are confirmed
-var activeHuggingFaceToken string // Stores HF_TOKEN if --token is used
+var activeHuggingFaceToken string // Hugging Face token from HF_TOKEN or the token file, see discoverHFToken
 
 func printUsage() {
 	baseCmd := filepath.Base(os.Args[0])
@@ -84,21 +79,59 @@ func printUsage() {
 	fmt.Fprintln(os.Stderr, "        llama-mac-arm    (Metal-enabled build for macOS ARM64)")
 	fmt.Fprintln(os.Stderr, "        llama-linux-cuda (CUDA-enabled build for Linux, matching your system's CUDA-compatible architecture)")
 
-	// Model Management (Search)
+	// Model Management (Search, aliases)
 	fmt.Fprintln(os.Stderr, "\n  Manage Hugging Face models:")
 	fmt.Fprintf(os.Stderr, "    %s model <subcommand> [options]\n", baseCmd)
 	fmt.Fprintln(os.Stderr, "      Subcommands for 'model':")
 	fmt.Fprintln(os.Stderr, "        search <query>   Search for models on Hugging Face.")
 	fmt.Fprintln(os.Stderr, "          Arguments for 'search':")
 	fmt.Fprintln(os.Stderr, "            <query>      The search term for models (e.g., 'bert', 'llama 7b gguf').")
+	fmt.Fprintln(os.Stderr, "            --sort <key> downloads (default), likes, lastModified or trending.")
+	fmt.Fprintln(os.Stderr, "            --author, --pipeline, --library <name>, --tag <tag> (repeatable)   Filter the results.")
+	fmt.Fprintln(os.Stderr, "            --exclude-gated, --exclude-private   Leave out models that need approval or access.")
+	fmt.Fprintln(os.Stderr, "            --limit <n>  Number of results, fetched page by page (default 20, 0 for all).")
+	fmt.Fprintln(os.Stderr, "            --json       Print the results as JSON on stdout.")
+	fmt.Fprintln(os.Stderr, "            --select     Pick a result by number, choose its GGUF files and download them (-c <n> concurrency).")
+	fmt.Fprintln(os.Stderr, "        info <owner/repo> [--json] [--revision <rev>]")
+	fmt.Fprintln(os.Stderr, "                         Show a repository's card metadata, files by directory and type,")
+	fmt.Fprintln(os.Stderr, "                         GGUF series, safetensors shard sets and total download size.")
+	fmt.Fprintln(os.Stderr, "        list [--json]    List the -m aliases (built-in and from the registry file).")
+	fmt.Fprintln(os.Stderr, "        add <alias> (-repo <owner/repo> [-file <glob>]... [-quant <q>] [-revision <rev>] | -url <url>)")
+	fmt.Fprintln(os.Stderr, "            [-sha256 <hex>] [-dir <dir>]   Add or replace an alias in the registry file.")
+	fmt.Fprintln(os.Stderr, "        remove <alias>   Remove an alias (a built-in one is hidden).")
+
+	// Authentication
+	fmt.Fprintln(os.Stderr, "\n  Manage the Hugging Face token (shared with huggingface-cli):")
+	fmt.Fprintf(os.Stderr, "    %s auth login             Validate a token read from stdin and save it (prompts on a terminal)\n", baseCmd)
+	fmt.Fprintln(os.Stderr, "        [--token <token>]      Take the token from the arguments instead (visible in ps and shell history)")
+	fmt.Fprintf(os.Stderr, "    %s auth logout            Remove the saved token\n", baseCmd)
+	fmt.Fprintf(os.Stderr, "    %s auth whoami            Show the account of the token in use\n", baseCmd)
+
+	// Verification
+	fmt.Fprintln(os.Stderr, "\n  Verify downloaded files against recorded size and sha256:")
+	fmt.Fprintf(os.Stderr, "    %s verify <dir>     (e.g., downloads/owner_repo)\n", baseCmd)
+
+	// Sync
+	fmt.Fprintln(os.Stderr, "\n  Update a Hugging Face download to the repository's current files:")
+	fmt.Fprintf(os.Stderr, "    %s sync <owner/repo> [--dry-run] [--prune] [--all] [-revision <rev>] [-dir <dir>]\n", baseCmd)
+	fmt.Fprintln(os.Stderr, "        [-include <glob>] [-exclude <glob>] [-c <n>]   Fetch only new and changed files")
+
+	// Local downloads
+	fmt.Fprintln(os.Stderr, "\n  Manage local downloads (directories with a download manifest):")
+	fmt.Fprintf(os.Stderr, "    %s list [--json] [<dir>...]   Show each download's source, files, disk usage and last update\n", baseCmd)
+	fmt.Fprintf(os.Stderr, "    %s rm <alias|repo|dir> [-y]   Delete a download's files and its manifest\n", baseCmd)
+
+	// GGUF inspection
+	fmt.Fprintln(os.Stderr, "\n  Show the metadata of a GGUF file (remote files are read with Range requests):")
+	fmt.Fprintf(os.Stderr, "    %s inspect <file-or-url> [--json] [--tensors]\n", baseCmd)
 
 	// Flags
 	fmt.Fprintln(os.Stderr, "\nFlags:")
 	fmt.Fprintln(os.Stderr, "  For downloader-specific flags (when providing URLs or using -f, -hf, -m):")
 	fmt.Fprintf(os.Stderr, "    Run '%s -h' or '%s --help' for a detailed list (e.g., -c, -f, -hf, -m, -select).\n", baseCmd, baseCmd)
 	fmt.Fprintln(os.Stderr, "  Common flags applicable in various contexts:")
-	fmt.Fprintln(os.Stderr, "    --token          Use HF_TOKEN environment variable for Hugging Face requests.")
-	fmt.Fprintln(os.Stderr, "                     (Applies to -hf, -m if model is from HF, and 'model search').")
+	fmt.Fprintln(os.Stderr, "    --token          Warn if no Hugging Face token is found. A token from HF_TOKEN, HF_TOKEN_PATH")
+	fmt.Fprintln(os.Stderr, "                     or 'auth login' is used automatically, and only sent to Hugging Face hosts.")
 	fmt.Fprintln(os.Stderr, "    -debug           Enable debug logging to log.log.")
 	fmt.Fprintln(os.Stderr, "  Other top-level flags/commands:")
 	fmt.Fprintln(os.Stderr, "    --update         Check for and apply application self-updates (use standalone).")
@@ -110,7 +143,11 @@ func printUsage() {
 	fmt.Fprintf(os.Stderr, "  Download (and select files) from a Hugging Face repo using token:\n    %s -hf TheBloke/Llama-2-7B-GGUF -select --token\n", baseCmd)
 	fmt.Fprintf(os.Stderr, "  Install a llama.cpp application:\n    %s install llama-linux-cuda\n", baseCmd)
 	fmt.Fprintf(os.Stderr, "  Update an installed llama.cpp application:\n    %s update llama\n", baseCmd)
+	fmt.Fprintf(os.Stderr, "  Add a model alias and download it:\n    %s model add my-qwen -repo Qwen/Qwen3-8B-GGUF -quant Q5_K_M && %s -m my-qwen\n", baseCmd, baseCmd)
 	fmt.Fprintf(os.Stderr, "  Search for Hugging Face models using a token:\n    %s model search \"llama 7b gguf\" --token\n", baseCmd)
+	fmt.Fprintf(os.Stderr, "  Search, pick a model and choose its GGUF files:\n    %s model search llama gguf --select\n", baseCmd)
+	fmt.Fprintf(os.Stderr, "  See what a repository holds before downloading it:\n    %s model info Qwen/Qwen3-8B-GGUF\n", baseCmd)
+	fmt.Fprintf(os.Stderr, "  Preview, then apply the upstream changes to a download:\n    %s sync Qwen/Qwen3-8B --dry-run && %s sync Qwen/Qwen3-8B --prune\n", baseCmd, baseCmd)
 	fmt.Fprintf(os.Stderr, "  Self-update the application:\n    %s --update\n", baseCmd)
 }
 
@@ -136,7 +173,9 @@ func main() {
 				fmt.Fprintln(os.Stderr, "[INFO] Attempting to restore terminal state due to panic...")
 				manager.Stop()
 			} else {
-				fmt.Print("\033[?25h") // Fallback cursor restoration
+				if _, isTerminal := terminalWidth(os.Stderr); isTerminal {
+					fmt.Fprint(os.Stderr, "\033[?25h") // Fallback cursor restoration
+				}
 			}
 			if exitCode == 0 {
 				exitCode = 2
@@ -164,10 +203,19 @@ func main() {
 			appLogger.Printf("Signal received: %s. Initiating shutdown.", sig)
 		}
 		fmt.Fprintln(os.Stderr, "\n[INFO] Interrupt signal received. Cleaning up and exiting...")
+		cancelDownloads()
+		// The workers stop at once, but still save their resume records on the way out.
+		if !waitForDownloadWorkers(10 * time.Second) {
+			if appLogger != nil {
+				appLogger.Println("Timed out waiting for the downloads to stop.")
+			}
+		}
 		if manager != nil { // manager is global
 			manager.Stop()
 		} else {
-			fmt.Print("\033[?25h") // Fallback
+			if _, isTerminal := terminalWidth(os.Stderr); isTerminal {
+				fmt.Fprint(os.Stderr, "\033[?25h") // Fallback
+			}
 		}
 		// logFile closed by main defer
 		if appLogger != nil {
@@ -181,12 +229,12 @@ func main() {
 
 func fetchSingleFileSize(fileURL string, hfToken string) (int64, error) {
 	appLogger.Printf("[fetchSingleFileSize] Getting size for: %s", fileURL)
-	client := http.Client{Timeout: 20 * DefaultClientTimeoutMultiplier * time.Second}
-	req, err := http.NewRequest("HEAD", fileURL, nil)
+	client := http.Client{Timeout: 20 * DefaultClientTimeoutMultiplier * time.Second, CheckRedirect: hfCheckRedirect}
+	req, err := http.NewRequestWithContext(downloadCtx, "HEAD", fileURL, nil)
 	if err != nil {
 		return -1, fmt.Errorf("creating HEAD request for %s: %w", fileURL, err)
 	}
-	if hfToken != "" && strings.Contains(fileURL, "huggingface.co") {
+	if hfToken != "" && isHFURL(fileURL) {
 		req.Header.Set("Authorization", "Bearer "+hfToken)
 	}
 	req.Header.Set("User-Agent", "Go-File-Downloader/1.1 (size-fetch)")
@@ -210,12 +258,12 @@ func fetchSingleFileSize(fileURL string, hfToken string) (int64, error) {
 }
 
 func fetchSingleFileSizeWithGET(fileURL string, hfToken string) (int64, error) {
-	client := http.Client{Timeout: 20 * DefaultClientTimeoutMultiplier * time.Second}
-	getReq, getErr := http.NewRequest("GET", fileURL, nil)
+	client := http.Client{Timeout: 20 * DefaultClientTimeoutMultiplier * time.Second, CheckRedirect: hfCheckRedirect}
+	getReq, getErr := http.NewRequestWithContext(downloadCtx, "GET", fileURL, nil)
 	if getErr != nil {
 		return -1, fmt.Errorf("creating GET request for %s (fallback for size): %w", fileURL, getErr)
 	}
-	if hfToken != "" && strings.Contains(fileURL, "huggingface.co") {
+	if hfToken != "" && isHFURL(fileURL) {
 		getReq.Header.Set("Authorization", "Bearer "+hfToken)
 	}
 	getReq.Header.Set("User-Agent", "Go-File-Downloader/1.1 (size-fetch-get)")
@@ -241,9 +289,10 @@ func runActual() int {
 	generalFlags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	var useHuggingFaceToken bool
 	var localDebugMode bool
+	var hfEndpointFlag string
 
 	generalFlags.BoolVar(&localDebugMode, "debug", debugMode, "Enable debug logging to log.log")
-	generalFlags.BoolVar(&useHuggingFaceToken, "token", false, "Use HF_TOKEN environment variable for Hugging Face requests")
+	generalFlags.BoolVar(&useHuggingFaceToken, "token", false, "Require a Hugging Face token (HF_TOKEN or 'dl auth login')")
 	generalFlags.SetOutput(io.Discard)
 	_ = generalFlags.Parse(os.Args[1:])
 
@@ -253,15 +302,32 @@ func runActual() int {
 			initLogging() // Re-initialize if debug was enabled late
 		}
 	}
-	if useHuggingFaceToken {
-		activeHuggingFaceToken = os.Getenv("HF_TOKEN")
-		if activeHuggingFaceToken == "" {
-			fmt.Fprintln(os.Stderr, "[WARN] --token specified, but HF_TOKEN environment variable is not set or is empty.")
-			appLogger.Println("[Main] --token specified, but HF_TOKEN environment variable not found or empty.")
-		} else {
-			appLogger.Println("[Main] HF_TOKEN found and will be used for Hugging Face requests.")
+	// Read directly from the arguments, as generalFlags stops at command words and downloader flags.
+	hfEndpointFlag = findFlagValue(os.Args[1:], "hf-endpoint")
+	if hfEndpointFlag == "" {
+		hfEndpointFlag = os.Getenv("HF_ENDPOINT")
+	}
+	if err := setHFEndpoint(hfEndpointFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// The token is used whenever one is found; --token only makes a missing one worth a warning.
+	// After 'auth login' it is that command's flag and carries the token to save.
+	isAuthLogin := len(os.Args) > 2 && os.Args[1] == "auth" && os.Args[2] == "login"
+	for _, arg := range os.Args[1:] {
+		if !isAuthLogin && (arg == "--token" || arg == "-token") {
+			useHuggingFaceToken = true
 		}
 	}
+	var tokenSource string
+	activeHuggingFaceToken, tokenSource = discoverHFToken()
+	if activeHuggingFaceToken != "" {
+		appLogger.Printf("[Main] Hugging Face token from %s will be used for Hugging Face requests.", tokenSource)
+	} else if useHuggingFaceToken {
+		tokenPath, _ := hfTokenPath()
+		fmt.Fprintf(os.Stderr, "[WARN] --token specified, but no token was found in HF_TOKEN or %s. Run 'dl auth login' to save one.\n", tokenPath)
+		appLogger.Println("[Main] --token specified, but no Hugging Face token was found.")
+	}
 
 	// Handle non-downloader commands first
 	if len(os.Args) > 1 {
@@ -269,8 +335,13 @@ func runActual() int {
 		if !strings.HasPrefix(command, "-") { // Is a command word
 			var tempManager *ProgressManager // For install/update commands that need a simple progress bar
 			argsWithoutFlags := []string{}
-			for _, arg := range os.Args[1:] {
-				if arg == "--token" || arg == "-debug" {
+			for i := 1; i < len(os.Args); i++ {
+				arg := os.Args[i]
+				if (arg == "--token" && !isAuthLogin) || arg == "-debug" || strings.HasPrefix(arg, "--hf-endpoint=") || strings.HasPrefix(arg, "-hf-endpoint=") {
+					continue
+				}
+				if arg == "--hf-endpoint" || arg == "-hf-endpoint" {
+					i++ // Skip its value too
 					continue
 				}
 				argsWithoutFlags = append(argsWithoutFlags, arg)
@@ -306,15 +377,59 @@ func runActual() int {
 						HandleRemoveLlamaApp(appName)
 					}
 					return 0
+				case "verify":
+					if len(argsWithoutFlags) < 2 {
+						fmt.Fprintln(os.Stderr, "Error: Missing <dir> for 'verify'.")
+						printUsage()
+						return 1
+					}
+					if !HandleVerify(argsWithoutFlags[1]) {
+						return 1
+					}
+					return 0
+				case "sync":
+					if !HandleSync(argsWithoutFlags[1:], activeHuggingFaceToken) {
+						return 1
+					}
+					return 0
+				case "list":
+					if !HandleList(argsWithoutFlags[1:]) {
+						return 1
+					}
+					return 0
+				case "rm":
+					if !HandleRm(argsWithoutFlags[1:]) {
+						return 1
+					}
+					return 0
+				case "inspect":
+					if !HandleInspect(argsWithoutFlags[1:], activeHuggingFaceToken) {
+						return 1
+					}
+					return 0
+				case "auth":
+					if !HandleAuth(argsWithoutFlags[1:]) {
+						return 1
+					}
+					return 0
 				case "model":
+					if len(argsWithoutFlags) > 1 && (argsWithoutFlags[1] == "list" || argsWithoutFlags[1] == "add" || argsWithoutFlags[1] == "remove") {
+						if !HandleModelRegistry(argsWithoutFlags[1:]) {
+							return 1
+						}
+						return 0
+					}
 					if len(argsWithoutFlags) > 1 && argsWithoutFlags[1] == "search" {
-						if len(argsWithoutFlags) > 2 {
-							HandleModelSearch(strings.Join(argsWithoutFlags[2:], " "), activeHuggingFaceToken)
-							return 0
+						if !HandleModelSearch(argsWithoutFlags[2:], activeHuggingFaceToken) {
+							return 1
 						}
-						fmt.Fprintln(os.Stderr, "Error: Missing search query for 'model search'.")
-						printUsage()
-						return 1
+						return 0
+					}
+					if len(argsWithoutFlags) > 1 && argsWithoutFlags[1] == "info" {
+						if !HandleModelInfo(argsWithoutFlags[2:], activeHuggingFaceToken) {
+							return 1
+						}
+						return 0
 					}
 					fmt.Fprintln(os.Stderr, "Error: Invalid subcommand for 'model'.")
 					printUsage()
@@ -330,21 +445,41 @@ func runActual() int {
 	var selectFile bool
 	var showSysInfo bool
 	var updateAppSelf bool
+	var limitRate string
+	var hfRevision, hfRepoType string
+	var quantPatterns globListFlag
 
 	downloaderFlags := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
 	baseCmdName := downloaderFlags.Name() // Store for usage message
 
 	// Re-declare common flags for help message, their values are already processed
 	downloaderFlags.BoolVar(&debugMode, "debug", debugMode, "Enable debug logging to log.log")
-	downloaderFlags.BoolVar(&useHuggingFaceToken, "token", useHuggingFaceToken, "Use HF_TOKEN environment variable")
+	downloaderFlags.BoolVar(&useHuggingFaceToken, "token", useHuggingFaceToken, "Warn if no Hugging Face token is found (HF_TOKEN, HF_TOKEN_PATH or the 'dl auth login' token file are used automatically)")
+	downloaderFlags.StringVar(&hfEndpointFlag, "hf-endpoint", hfEndpointFlag, "Hugging Face endpoint or mirror, e.g. https://hf-mirror.com (default: HF_ENDPOINT or https://huggingface.co)")
 
 	downloaderFlags.BoolVar(&showSysInfo, "t", false, "Show system hardware information and exit")
 	downloaderFlags.BoolVar(&updateAppSelf, "update", false, "Check for and apply application self-updates")
 	downloaderFlags.IntVar(&concurrency, "c", 3, "Number of concurrent downloads & display lines")
+	downloaderFlags.IntVar(&downloadSegments, "segments", 1, "Parallel connections (byte ranges) per large file")
+	downloaderFlags.IntVar(&downloadRetryPolicy.MaxRetries, "retries", downloadRetryPolicy.MaxRetries, "Retries per file after a transient failure (0 disables)")
+	downloaderFlags.DurationVar(&downloadRetryPolicy.BaseDelay, "retry-delay", downloadRetryPolicy.BaseDelay, "Initial backoff between retries, doubled each time (with jitter)")
+	downloaderFlags.DurationVar(&downloadRetryPolicy.MaxDelay, "retry-max-delay", downloadRetryPolicy.MaxDelay, "Upper bound for the backoff between retries")
+	downloaderFlags.StringVar(&progressMode, "progress", progressMode, "Progress output: auto (tty on a terminal, summary otherwise), tty (redrawn bars), summary (periodic lines), plain (one line per state change) or json (newline-delimited events on stdout)")
+	downloaderFlags.StringVar(&limitRate, "limit-rate", "", "Cap the combined download speed, e.g. 50M or 512K (SIGUSR1 halves, SIGUSR2 doubles it)")
+	downloaderFlags.Var(hostRateFlag{}, "limit-host", "Cap the speed of downloads from one host, e.g. huggingface.co=20M (repeatable)")
 	downloaderFlags.StringVar(&urlsFilePath, "f", "", "Path to text file containing URLs")
 	downloaderFlags.StringVar(&hfRepoInput, "hf", "", "Hugging Face repository ID or URL")
-	downloaderFlags.StringVar(&modelName, "m", "", "Predefined model alias")
+	downloaderFlags.StringVar(&hfRepoType, "repo-type", "", "Type of the -hf repository: model, dataset or space (default: from a datasets/ or spaces/ path, else model)")
+	downloaderFlags.Var((*globListFlag)(&downloadFilter.Include), "include", "Only download files whose path matches this glob, e.g. '*.safetensors' or 'onnx/**' (repeatable)")
+	downloaderFlags.Var((*globListFlag)(&downloadFilter.Exclude), "exclude", "Skip files whose path matches this glob (repeatable, applied after -include)")
+	downloaderFlags.BoolVar(&hfCacheMode, "hf-cache", false, "Store -hf downloads in the Hugging Face hub cache (HF_HUB_CACHE, HF_HOME/hub or ~/.cache/huggingface/hub) so other tools find them")
+	downloaderFlags.StringVar(&hfRevision, "revision", defaultHFRevision, "Branch, tag, PR ref (refs/pr/N) or commit sha to download with -hf")
+	downloaderFlags.StringVar(&modelName, "m", "", "Model alias, built-in or from the registry file (see 'model list')")
 	downloaderFlags.BoolVar(&selectFile, "select", false, "Interactively select GGUF files from Hugging Face repository")
+	downloaderFlags.Var(&quantPatterns, "quant", "Select the GGUF file or series with this quantization from -hf without prompting, e.g. Q4_K_M or 'Q5_*', or 'auto' for the largest that fits into memory (repeatable)")
+	downloaderFlags.BoolVar(&mergeSplits, "merge", false, "Merge each downloaded split GGUF (-00001-of-0000N.gguf) into a single .gguf after validating its parts")
+	downloaderFlags.Var(&shardTensorPrefixes, "shards", "Only download the safetensors shards holding tensors whose name starts with this prefix, e.g. 'model.layers.10.' (repeatable or comma-separated)")
+	downloaderFlags.IntVar(&fitContextLength, "ctx", fitContextLength, "Context length assumed when estimating whether a GGUF fits into memory (-select, -quant auto)")
 
 	downloaderFlags.Usage = func() {
 		fmt.Fprintf(downloaderFlags.Output(), "Usage: %s [flags] <URL1> <URL2> ...\n", baseCmdName)
@@ -357,7 +492,18 @@ func runActual() int {
 		fmt.Fprintf(downloaderFlags.Output(), "  %s -f urls.txt -c 5\n", baseCmdName)
 		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf TheBloke/Llama-2-7B-GGUF\n", baseCmdName)
 		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf Org/Model-Name -select --token\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf Org/Model-Name -revision refs/pr/12\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf Qwen/Qwen3-8B-GGUF -quant Q4_K_M\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf Qwen/Qwen3-8B-GGUF -quant auto -ctx 32768\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf Qwen/Qwen3-0.6B -hf-cache\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf Qwen/Qwen3-0.6B -hf-endpoint https://hf-mirror.com\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf datasets/HuggingFaceFW/fineweb-edu -include 'sample/10BT/*'\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf deepseek-ai/DeepSeek-R1 -include '*.safetensors' -include '*.json' -exclude 'original/**'\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf deepseek-ai/DeepSeek-R1 -shards model.embed_tokens.,model.layers.0.\n", baseCmdName)
 		fmt.Fprintf(downloaderFlags.Output(), "  %s -m qwen3-8b --token\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -segments 8 https://example.com/large-model.gguf\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s --progress=json -f urls.txt > events.ndjson\n", baseCmdName)
+		fmt.Fprintf(downloaderFlags.Output(), "  %s -hf TheBloke/Llama-2-7B-GGUF -limit-rate 50M -limit-host cdn-lfs.huggingface.co=20M\n", baseCmdName)
 		fmt.Fprintln(downloaderFlags.Output(), "\nFor help on application management ('install', 'update', 'remove', 'model search') or general commands ('--update', '-t'):")
 		fmt.Fprintf(downloaderFlags.Output(), "  Run '%s' with an invalid command or no command to see the general usage structure.\n", baseCmdName)
 		fmt.Fprintf(downloaderFlags.Output(), "  Example: %s model search \"your query\" --token\n", baseCmdName)
@@ -408,18 +554,35 @@ func runActual() int {
 		return 1
 	}
 
+	// An alias of a repository is downloaded like -hf with the alias' files, quant and
+	// revision; an alias of a URL like a direct download.
+	var aliasName string
+	var alias modelAlias
+	if modelName != "" {
+		var aliasErr error
+		if alias, aliasErr = lookupModelAlias(modelName); aliasErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", aliasErr)
+			return 1
+		}
+		aliasName = modelName
+		appLogger.Printf("Model alias %s -> %s", aliasName, alias.describe())
+		if alias.Repo != "" {
+			hfRepoInput, modelName = alias.Repo, ""
+			if alias.Revision != "" && hfRevision == defaultHFRevision {
+				hfRevision = alias.Revision
+			}
+			if alias.Quant != "" && len(quantPatterns) == 0 {
+				quantPatterns = append(quantPatterns, alias.Quant)
+			}
+		}
+	}
+
 	effectiveConcurrency := concurrency
 	if modelName != "" {
 		effectiveConcurrency = 1
 		appLogger.Printf("Concurrency display overridden to 1 for -m.")
 	} else if hfRepoInput != "" {
-		maxHfConcurrency := 4
-		if selectFile {
-			maxHfConcurrency = 10
-		} // Allow more for size pre-fetching phase
-		if effectiveConcurrency <= 0 || effectiveConcurrency > maxHfConcurrency {
-			effectiveConcurrency = maxHfConcurrency
-		}
+		effectiveConcurrency = hfConcurrency(concurrency, selectFile || len(quantPatterns) > 0)
 		appLogger.Printf("Effective concurrency for -hf: %d", effectiveConcurrency)
 	} else { // File list or direct URLs
 		maxFileConcurrency := 100
@@ -434,21 +597,66 @@ func runActual() int {
 	if effectiveConcurrency <= 0 {
 		effectiveConcurrency = 1
 	}
+	if downloadRetryPolicy.MaxRetries < 0 {
+		downloadRetryPolicy.MaxRetries = 0
+	}
+	if downloadSegments < 1 {
+		downloadSegments = 1
+	} else if downloadSegments > maxDownloadSegments {
+		downloadSegments = maxDownloadSegments
+	}
+	if hfRevision != defaultHFRevision && hfRepoInput == "" {
+		fmt.Fprintln(os.Stderr, "[WARN] -revision is only used with -hf and is ignored here.")
+	}
+	if hfRepoType != "" && hfRepoInput == "" {
+		fmt.Fprintln(os.Stderr, "[WARN] -repo-type is only used with -hf and is ignored here.")
+	}
+	if hfCacheMode && hfRepoInput == "" {
+		fmt.Fprintln(os.Stderr, "[WARN] -hf-cache is only used with -hf and is ignored here.")
+		hfCacheMode = false
+	}
+	if hfCacheMode && mergeSplits {
+		fmt.Fprintln(os.Stderr, "Error: -merge cannot be used with -hf-cache; the cache snapshot must match the repository.")
+		return 1
+	}
+	if len(shardTensorPrefixes) > 0 && hfRepoInput == "" {
+		fmt.Fprintln(os.Stderr, "[WARN] -shards is only used with -hf and is ignored here.")
+	}
+	if len(shardTensorPrefixes) > 0 && (selectFile || len(quantPatterns) > 0) {
+		fmt.Fprintln(os.Stderr, "Error: -shards selects safetensors shards and cannot be combined with -select or -quant.")
+		return 1
+	}
+	if !isValidProgressMode(progressMode) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -progress '%s' (expected auto, tty, summary, plain or json).\n", progressMode)
+		return 1
+	}
+	if limitRate != "" {
+		rate, err := parseRate(limitRate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -limit-rate: %v\n", err)
+			return 1
+		}
+		downloadRateLimit.setLimit(rate)
+	}
+	if summary := rateLimitSummary(); summary != "" {
+		appLogger.Printf("[Main] Bandwidth limits: %s", summary)
+	}
+	watchRateLimitSignals()
 
-	appLogger.Printf("Effective Display Concurrency: %d. DebugMode: %t, UseHFToken: %t, FilePath: '%s', HF Repo Input: '%s', ModelName: '%s', SelectMode: %t, Args: %v",
-		effectiveConcurrency, debugMode, useHuggingFaceToken, urlsFilePath, hfRepoInput, modelName, selectFile, downloaderFlags.Args())
+	appLogger.Printf("Effective Display Concurrency: %d. Segments: %d, DebugMode: %t, UseHFToken: %t, FilePath: '%s', HF Repo Input: '%s', ModelName: '%s', SelectMode: %t, Args: %v",
+		effectiveConcurrency, downloadSegments, debugMode, useHuggingFaceToken, urlsFilePath, hfRepoInput, modelName, selectFile, downloaderFlags.Args())
 
-	var finalDownloadItems []DownloadItem
-	var downloadDir string
-	hfFileSizes := make(map[string]int64)
+	var plan *downloadPlan
 
 	fmt.Fprintln(os.Stderr, "[INFO] Initializing downloader...")
 
 	if modelName != "" {
-		modelURL, found := modelRegistry[modelName]
-		if !found {
-			fmt.Fprintf(os.Stderr, "Error: Model alias '%s' not recognized.\n", modelName)
-			return 1
+		modelURL := withHFEndpoint(alias.URL)
+		if repo, ok := hfRepoFromURL(modelURL); ok {
+			if accessErr := checkHFRepoAccess(repo, activeHuggingFaceToken); accessErr != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", accessErr)
+				return 1
+			}
 		}
 		var preferredFilename string
 		if pu, pe := url.Parse(modelURL); pe == nil {
@@ -456,216 +664,30 @@ func runActual() int {
 		} else {
 			preferredFilename = "download.file"
 		}
-		finalDownloadItems = append(finalDownloadItems, DownloadItem{URL: modelURL, PreferredFilename: preferredFilename})
-		safeModelName := strings.ReplaceAll(strings.ReplaceAll(modelName, string(os.PathSeparator), "_"), "..", "")
-		downloadDir = filepath.Join("downloads", safeModelName)
+		plan = &downloadPlan{
+			Items: []DownloadItem{{URL: modelURL, PreferredFilename: preferredFilename, ExpectedSHA256: alias.SHA256}},
+			Dir:   alias.downloadDir(aliasName),
+			Alias: aliasName,
+		}
 	} else if hfRepoInput != "" {
-		fmt.Fprintf(os.Stderr, "[INFO] Preparing to fetch from Hugging Face repository: %s\n", hfRepoInput)
-		allRepoFilesFromAPI, errHf := fetchHuggingFaceURLs(hfRepoInput, activeHuggingFaceToken)
-		if errHf != nil {
-			fmt.Fprintf(os.Stderr, "Error fetching from HF '%s': %v\n", hfRepoInput, errHf)
+		var prepErr error
+		plan, prepErr = prepareHFDownload(hfDownloadRequest{
+			RepoInput:     hfRepoInput,
+			RepoType:      hfRepoType,
+			Revision:      hfRevision,
+			Select:        selectFile,
+			QuantPatterns: quantPatterns,
+			AliasName:     aliasName,
+			Alias:         alias,
+			Concurrency:   effectiveConcurrency,
+		}, activeHuggingFaceToken)
+		if prepErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", prepErr)
 			return 1
 		}
-		if len(allRepoFilesFromAPI) == 0 {
+		if plan == nil {
 			return 0
 		}
-
-		selectedHfFiles := []HFFile{}
-		if selectFile {
-			appLogger.Println("[Main] Select mode enabled. Processing GGUF files.")
-			fmt.Fprintln(os.Stderr, "[INFO] Identifying GGUF files and series for selection...")
-
-			ggufSeriesMap := make(map[string]*GGUFSeriesInfo)
-			standaloneGGUFs := []HFFile{}
-			var filesToGetSize []HFFile
-
-			for _, hfFile := range allRepoFilesFromAPI {
-				if strings.HasSuffix(strings.ToLower(hfFile.Filename), ".gguf") {
-					filesToGetSize = append(filesToGetSize, hfFile)
-					matches := ggufSeriesRegex.FindStringSubmatch(hfFile.Filename)
-					if len(matches) == 4 {
-						baseName := matches[1]
-						partNumStr := matches[2]
-						totalPartsStr := matches[3]
-						partNum, _ := strconv.Atoi(partNumStr)
-						totalPartsInName, _ := strconv.Atoi(totalPartsStr)
-						seriesKey := fmt.Sprintf("%s-of-%s", baseName, totalPartsStr)
-						if _, ok := ggufSeriesMap[seriesKey]; !ok {
-							ggufSeriesMap[seriesKey] = &GGUFSeriesInfo{BaseName: baseName, TotalParts: totalPartsInName, SeriesKey: seriesKey}
-						}
-						seriesInfo := ggufSeriesMap[seriesKey]
-						seriesInfo.FilesWithPart = append(seriesInfo.FilesWithPart, GGUFFileWithPartNum{File: hfFile, PartNum: partNum})
-					} else {
-						standaloneGGUFs = append(standaloneGGUFs, hfFile)
-					}
-				}
-			}
-			appLogger.Printf("[Main] Found %d GGUF series groups and %d standalone GGUF files.", len(ggufSeriesMap), len(standaloneGGUFs))
-
-			if len(filesToGetSize) > 0 {
-				fmt.Fprintf(os.Stderr, "[INFO] Fetching sizes for %d GGUF file(s) (this may take a moment)...\n", len(filesToGetSize))
-				var sizeWG sync.WaitGroup
-				sizeSem := make(chan struct{}, effectiveConcurrency) // Use effectiveConcurrency for HEAD/GET requests
-				processedCount := 0
-				totalToProcess := len(filesToGetSize)
-				var mu sync.Mutex
-
-				for _, hfFileToSize := range filesToGetSize {
-					sizeWG.Add(1)
-					go func(file HFFile) {
-						defer sizeWG.Done()
-						sizeSem <- struct{}{}
-						defer func() { <-sizeSem }()
-						size, errSize := fetchSingleFileSize(file.URL, activeHuggingFaceToken) // Changed variable name
-						mu.Lock()
-						if errSize != nil {
-							appLogger.Printf("[SelectSizeFetch] Error getting size for %s: %v", file.Filename, errSize)
-							hfFileSizes[file.URL] = -1
-						} else {
-							hfFileSizes[file.URL] = size
-						}
-						processedCount++
-						fmt.Fprintf(os.Stderr, "\rFetching GGUF sizes: %d/%d complete...", processedCount, totalToProcess)
-						mu.Unlock()
-					}(hfFileToSize)
-				}
-				sizeWG.Wait()
-				fmt.Fprintln(os.Stderr, "\rFetching GGUF sizes: All complete.               ")
-			}
-
-			selectableDisplayItems := []SelectableGGUFItem{}
-			for _, seriesInfo := range ggufSeriesMap {
-				seriesInfo.TotalSize = 0
-				seriesInfo.ActualTotalPartsFound = 0
-				validParts := []GGUFFileWithPartNum{}
-				for _, partFile := range seriesInfo.FilesWithPart {
-					partSize, ok := hfFileSizes[partFile.File.URL]
-					if ok && partSize > -1 {
-						seriesInfo.TotalSize += partSize
-						partFile.Size = partSize
-						seriesInfo.ActualTotalPartsFound++
-						validParts = append(validParts, partFile)
-					} else {
-						appLogger.Printf("[SelectBuild] Part %s of series %s has unknown size or fetch error, excluding from total.", partFile.File.Filename, seriesInfo.BaseName)
-					}
-				}
-				seriesInfo.FilesWithPart = validParts
-				sort.Slice(seriesInfo.FilesWithPart, func(i, j int) bool { return seriesInfo.FilesWithPart[i].PartNum < seriesInfo.FilesWithPart[j].PartNum })
-				filesForThisSeries := []HFFile{}
-				for _, p := range seriesInfo.FilesWithPart {
-					filesForThisSeries = append(filesForThisSeries, p.File)
-				}
-				isComplete := seriesInfo.ActualTotalPartsFound == seriesInfo.TotalParts && seriesInfo.TotalParts > 0
-				completenessMark := ""
-				if seriesInfo.TotalParts > 0 && !isComplete {
-					completenessMark = fmt.Sprintf(" (INCOMPLETE: %d/%d parts found)", seriesInfo.ActualTotalPartsFound, seriesInfo.TotalParts)
-				} else if seriesInfo.TotalParts == 0 && seriesInfo.ActualTotalPartsFound > 0 {
-					completenessMark = " (WARNING: total parts in name is 0)"
-				}
-				displayName := fmt.Sprintf("Series: %s (%d parts, %s)%s", seriesInfo.BaseName, seriesInfo.ActualTotalPartsFound, formatBytes(seriesInfo.TotalSize), completenessMark)
-				selectableDisplayItems = append(selectableDisplayItems, SelectableGGUFItem{DisplayName: displayName, FilesToDownload: filesForThisSeries, IsSeries: true, IsComplete: isComplete || (seriesInfo.TotalParts == 0 && seriesInfo.ActualTotalPartsFound > 0)})
-			}
-			for _, standaloneFile := range standaloneGGUFs {
-				size, ok := hfFileSizes[standaloneFile.URL]
-				if !ok || size == -1 {
-					size = 0
-					appLogger.Printf("[SelectBuild] Standalone GGUF %s has unknown size.", standaloneFile.Filename)
-				}
-				displayName := fmt.Sprintf("File: %s (%s)", standaloneFile.Filename, formatBytes(size))
-				selectableDisplayItems = append(selectableDisplayItems, SelectableGGUFItem{DisplayName: displayName, FilesToDownload: []HFFile{standaloneFile}, IsSeries: false, IsComplete: true})
-			}
-			sort.Slice(selectableDisplayItems, func(i, j int) bool {
-				return selectableDisplayItems[i].DisplayName < selectableDisplayItems[j].DisplayName
-			})
-
-			if len(selectableDisplayItems) == 0 {
-				fmt.Fprintln(os.Stderr, "[INFO] No GGUF files found in the repository for selection.")
-				appLogger.Println("[MainSelect] No GGUF files found for selection. Downloading all files as fallback.")
-				selectedHfFiles = allRepoFilesFromAPI
-			} else {
-				fmt.Fprintln(os.Stderr, "\nAvailable GGUF files/series for download:")
-				for i, item := range selectableDisplayItems {
-					fmt.Fprintf(os.Stderr, "%3d. %s\n", i+1, item.DisplayName)
-				}
-				fmt.Fprintln(os.Stderr, "---")
-				for {
-					fmt.Fprint(os.Stderr, "Enter numbers (e.g., 1,3), 'all' (listed GGUFs), or 'none': ")
-					inputReader := bufio.NewReader(os.Stdin)
-					userInput, _ := inputReader.ReadString('\n')
-					userInput = strings.TrimSpace(strings.ToLower(userInput))
-					if userInput == "all" {
-						for _, item := range selectableDisplayItems {
-							if item.IsSeries && !item.IsComplete {
-								fmt.Fprintf(os.Stderr, "[WARN] Skipping incomplete series: %s\n", item.DisplayName)
-								appLogger.Printf("[MainSelect] User chose 'all', skipping incomplete series: %s", item.DisplayName)
-								continue
-							}
-							selectedHfFiles = append(selectedHfFiles, item.FilesToDownload...)
-						}
-						appLogger.Printf("[MainSelect] User chose 'all', selected %d files.", len(selectedHfFiles))
-						break
-					}
-					if userInput == "none" {
-						fmt.Fprintln(os.Stderr, "[INFO] No files selected for download.")
-						appLogger.Println("[MainSelect] User chose 'none'.")
-						break
-					}
-					parts := strings.Split(userInput, ",")
-					tempSelectedFiles := []HFFile{}
-					validSelection := true
-					if len(parts) == 0 && userInput != "" {
-						validSelection = false
-					}
-					for _, p := range parts {
-						trimmedPart := strings.TrimSpace(p)
-						if trimmedPart == "" {
-							continue
-						}
-						idx, errConv := strconv.Atoi(trimmedPart)
-						if errConv != nil || idx < 1 || idx > len(selectableDisplayItems) {
-							fmt.Fprintf(os.Stderr, "[ERROR] Invalid input: '%s'. Please enter numbers from 1 to %d, 'all', or 'none'.\n", p, len(selectableDisplayItems))
-							validSelection = false
-							break
-						}
-						selectedItem := selectableDisplayItems[idx-1]
-						if selectedItem.IsSeries && !selectedItem.IsComplete {
-							fmt.Fprintf(os.Stderr, "[WARN] Selected series '%s' is incomplete. Skipping this item.\n", selectedItem.DisplayName)
-							appLogger.Printf("[MainSelect] User selected item %d ('%s') which is an incomplete series. Skipping.", idx, selectedItem.DisplayName)
-							continue
-						}
-						tempSelectedFiles = append(tempSelectedFiles, selectedItem.FilesToDownload...)
-					}
-					if validSelection {
-						selectedHfFiles = tempSelectedFiles
-						appLogger.Printf("[MainSelect] User selected items, resulting in %d files for download.", len(selectedHfFiles))
-						break
-					}
-				}
-			}
-		} else {
-			selectedHfFiles = allRepoFilesFromAPI
-			appLogger.Println("[Main] Select mode not enabled. Preparing to download all files from HF repo.")
-		}
-
-		for _, hfFile := range selectedHfFiles {
-			finalDownloadItems = append(finalDownloadItems, DownloadItem{URL: hfFile.URL, PreferredFilename: hfFile.Filename})
-		}
-		var repoOwnerClean, repoNameClean string
-		cleanedRepoInput := strings.TrimPrefix(hfRepoInput, "https://huggingface.co/")
-		cleanedRepoInput = strings.TrimPrefix(cleanedRepoInput, "http://huggingface.co/")
-		parts := strings.Split(cleanedRepoInput, "/")
-		if len(parts) >= 2 {
-			repoOwnerClean = strings.ReplaceAll(strings.ReplaceAll(parts[0], string(os.PathSeparator), "_"), "..", "")
-			repoNameClean = strings.ReplaceAll(strings.ReplaceAll(parts[1], string(os.PathSeparator), "_"), "..", "")
-			repoNameClean = strings.Split(repoNameClean, "?")[0]
-			repoNameClean = strings.Split(repoNameClean, "#")[0]
-			downloadDir = filepath.Join("downloads", fmt.Sprintf("%s_%s", repoOwnerClean, repoNameClean))
-		} else {
-			safeRepoName := strings.ReplaceAll(strings.ReplaceAll(cleanedRepoInput, string(os.PathSeparator), "_"), "..", "")
-			downloadDir = filepath.Join("downloads", fmt.Sprintf("hf_%s", safeRepoName))
-			appLogger.Printf("Could not parse owner/repo from hf input '%s', using dir %s", hfRepoInput, downloadDir)
-		}
-
 	} else {
 		if selectFile {
 			fmt.Fprintln(os.Stderr, "[WARN] -select flag is ignored when using -f or direct URLs.")
@@ -690,13 +712,228 @@ func runActual() int {
 				return 1
 			}
 		}
+		plan = &downloadPlan{Dir: "downloads"}
 		for _, urlStr := range inputURLs {
-			finalDownloadItems = append(finalDownloadItems, DownloadItem{URL: urlStr, PreferredFilename: ""})
+			plan.Items = append(plan.Items, DownloadItem{URL: urlStr, PreferredFilename: ""})
+		}
+		appLogger.Printf("Processed %d URLs for download.", len(plan.Items))
+	}
+
+	if hfRepoInput == "" && downloadFilter.active() {
+		totalItems := len(plan.Items)
+		plan.Items = filterDownloadItems(plan.Items, downloadFilter)
+		fmt.Fprintf(os.Stderr, "[INFO] %d of %d file(s) match -include/-exclude.\n", len(plan.Items), totalItems)
+	}
+
+	return runDownloads(plan, effectiveConcurrency, activeHuggingFaceToken)
+}
+
+// hfConcurrency limits the requested concurrency for a Hugging Face repository
+// download; selecting GGUF files allows more for the size and header pre-fetching.
+func hfConcurrency(requested int, selecting bool) int {
+	maxHfConcurrency := 4
+	if selecting {
+		maxHfConcurrency = 10
+	} // Allow more for size pre-fetching phase
+	if requested <= 0 || requested > maxHfConcurrency {
+		return maxHfConcurrency
+	}
+	return requested
+}
+
+// hfDownloadRequest describes what to fetch from a Hugging Face repository. runActual
+// fills it from -hf and its flags (or a registry alias), 'model search --select' from
+// the picked result.
+type hfDownloadRequest struct {
+	RepoInput     string
+	RepoType      string
+	Revision      string
+	Select        bool
+	QuantPatterns []string
+	AliasName     string // Set for -m, with Alias
+	Alias         modelAlias
+	Concurrency   int
+	ConfirmAll    bool // Ask before downloading every file of a repository without GGUF files to select
+}
+
+// downloadPlan is the set of files runDownloads fetches, and what it needs to record
+// and validate afterwards.
+type downloadPlan struct {
+	Items              []DownloadItem
+	Dir                string
+	FileSizes          map[string]int64 // URL -> size, where the listing already gave it
+	RepoID, CommitSHA  string           // Set for -hf downloads, recorded in the manifest (RepoID as in URLs, e.g. datasets/owner/repo)
+	Revision           string
+	Alias              string             // -m alias, recorded in the manifest
+	Selection          *DownloadSelection // How an -hf download picked its files, recorded in the manifest
+	HFCache            *hfCacheRepo       // Set for -hf-cache downloads
+	SafetensorsIndexes []*safetensorsIndex
+}
+
+// prepareHFDownload lists a repository, applies the alias files, -include/-exclude,
+// -shards and the GGUF selection (menu or -quant), and returns what to download. A
+// nil plan without an error means there is nothing to download.
+func prepareHFDownload(req hfDownloadRequest, hfToken string) (*downloadPlan, error) {
+	fmt.Fprintf(os.Stderr, "[INFO] Preparing to fetch from Hugging Face repository: %s\n", req.RepoInput)
+	repo, errRepo := parseHuggingFaceRepo(req.RepoInput, req.RepoType)
+	if errRepo != nil {
+		return nil, errRepo
+	}
+	if accessErr := checkHFRepoAccess(repo, hfToken); accessErr != nil {
+		return nil, accessErr
+	}
+	allRepoFilesFromAPI, commitSHA, errHf := fetchHuggingFaceURLs(repo, req.Revision, hfToken)
+	if errHf != nil {
+		return nil, fmt.Errorf("fetching from HF '%s': %w", req.RepoInput, errHf)
+	}
+	if len(allRepoFilesFromAPI) == 0 {
+		return nil, nil
+	}
+	alias := req.Alias
+	if len(alias.Files) > 0 {
+		allRepoFilesFromAPI = filterHFFiles(allRepoFilesFromAPI, fileFilter{Include: alias.Files})
+		if len(allRepoFilesFromAPI) == 0 {
+			return nil, fmt.Errorf("%s has no file matching alias '%s' (%s)", repo, req.AliasName, strings.Join(alias.Files, ", "))
+		}
+	}
+	plan := &downloadPlan{FileSizes: make(map[string]int64), RepoID: repo.String(), CommitSHA: commitSHA, Revision: req.Revision}
+	plan.Selection = &DownloadSelection{Files: alias.Files, Include: downloadFilter.Include, Exclude: downloadFilter.Exclude}
+	plan.SafetensorsIndexes = loadSafetensorsIndexes(allRepoFilesFromAPI, hfToken)
+	if len(plan.SafetensorsIndexes) > 0 {
+		inspectSafetensorsShards(plan.SafetensorsIndexes, allRepoFilesFromAPI, req.Concurrency, hfToken)
+	} else if len(shardTensorPrefixes) > 0 {
+		return nil, fmt.Errorf("-shards given, but the repository has no readable *.safetensors.index.json")
+	}
+	if downloadFilter.active() {
+		totalFiles := len(allRepoFilesFromAPI)
+		allRepoFilesFromAPI = filterHFFiles(allRepoFilesFromAPI, downloadFilter)
+		fmt.Fprintf(os.Stderr, "[INFO] %d of %d file(s) match -include/-exclude.\n", len(allRepoFilesFromAPI), totalFiles)
+		if len(allRepoFilesFromAPI) == 0 {
+			return nil, nil
 		}
-		appLogger.Printf("Processed %d URLs for download.", len(finalDownloadItems))
-		downloadDir = "downloads"
 	}
+	for _, hfFile := range allRepoFilesFromAPI {
+		if hfFile.Size > 0 { // Known from the tree API, no HEAD request needed
+			plan.FileSizes[hfFile.URL] = hfFile.Size
+		}
+	}
+
+	selectedHfFiles := []HFFile{}
+	if req.Select || len(req.QuantPatterns) > 0 {
+		appLogger.Println("[Main] Select mode enabled. Processing GGUF files.")
+		fmt.Fprintln(os.Stderr, "[INFO] Identifying GGUF files and series for selection...")
+		selectableDisplayItems := buildGGUFSelection(allRepoFilesFromAPI, plan.FileSizes, req.Concurrency, hfToken)
 
+		if len(selectableDisplayItems) == 0 && len(req.QuantPatterns) > 0 {
+			return nil, fmt.Errorf("-quant given, but the repository has no GGUF files")
+		} else if len(selectableDisplayItems) == 0 {
+			fmt.Fprintln(os.Stderr, "[INFO] No GGUF files found in the repository for selection.")
+			if req.ConfirmAll && !confirmDownloadAll(allRepoFilesFromAPI) {
+				return nil, nil
+			}
+			appLogger.Println("[MainSelect] No GGUF files found for selection. Downloading all files as fallback.")
+			selectedHfFiles = allRepoFilesFromAPI
+		} else if len(req.QuantPatterns) > 0 {
+			plan.Selection.Picked = "gguf"
+			if hasAutoQuant(req.QuantPatterns) {
+				fetchGGUFSelectionMetadata(selectableDisplayItems, req.Concurrency, hfToken)
+				annotateMemoryFit(selectableDisplayItems, detectSystemMemory())
+			}
+			quantFiles, quantErr := selectGGUFByQuant(selectableDisplayItems, req.QuantPatterns)
+			if quantErr != nil {
+				return nil, quantErr
+			}
+			selectedHfFiles = quantFiles
+		} else {
+			plan.Selection.Picked = "gguf"
+			fetchGGUFSelectionMetadata(selectableDisplayItems, req.Concurrency, hfToken)
+			annotateMemoryFit(selectableDisplayItems, detectSystemMemory())
+			selectedHfFiles = promptGGUFSelection(selectableDisplayItems)
+		}
+	} else if len(shardTensorPrefixes) > 0 {
+		plan.Selection.Picked = "shards"
+		var shardsErr error
+		if selectedHfFiles, shardsErr = selectShardsByPrefix(allRepoFilesFromAPI, plan.SafetensorsIndexes, shardTensorPrefixes); shardsErr != nil {
+			return nil, shardsErr
+		}
+	} else {
+		selectedHfFiles = allRepoFilesFromAPI
+		appLogger.Println("[Main] Select mode not enabled. Preparing to download all files from HF repo.")
+	}
+	if alias.SHA256 != "" {
+		if len(selectedHfFiles) != 1 {
+			fmt.Fprintf(os.Stderr, "[WARN] Alias '%s' has a sha256, but selects %d files; it is not checked.\n", req.AliasName, len(selectedHfFiles))
+		} else if listed := selectedHfFiles[0].SHA256; listed != "" && !strings.EqualFold(listed, alias.SHA256) {
+			return nil, fmt.Errorf("%s in %s has sha256 %s, alias '%s' expects %s. The file has changed; update the alias with 'dl model add'", selectedHfFiles[0].Filename, repo, listed, req.AliasName, alias.SHA256)
+		} else {
+			selectedHfFiles[0].SHA256 = alias.SHA256
+		}
+	}
+	markSelectedShards(plan.SafetensorsIndexes, selectedHfFiles)
+
+	if hfCacheMode {
+		var cacheErr error
+		if plan.HFCache, cacheErr = openHFCacheRepo(repo, commitSHA); cacheErr != nil {
+			return nil, fmt.Errorf("-hf-cache: %w", cacheErr)
+		}
+		if refErr := plan.HFCache.writeRef(req.Revision); refErr != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Could not record ref '%s' in the cache: %v\n", req.Revision, refErr)
+		}
+		var reused int
+		selectedHfFiles, reused = plan.HFCache.linkExisting(selectedHfFiles)
+		if reused > 0 {
+			fmt.Fprintf(os.Stderr, "[INFO] %d file(s) already in the Hugging Face cache, linked without downloading.\n", reused)
+		}
+		if len(selectedHfFiles) == 0 {
+			fmt.Fprintf(os.Stderr, "[INFO] Snapshot: %s\n", plan.HFCache.snapshotDir())
+			return nil, nil
+		}
+	}
+	for _, hfFile := range selectedHfFiles {
+		plan.Items = append(plan.Items, DownloadItem{URL: hfFile.URL, PreferredFilename: hfFile.Filename, ExpectedSHA256: hfFile.SHA256, BlobID: hfFile.BlobID})
+	}
+	plan.Dir = hfDownloadDir(repo)
+	if req.AliasName != "" {
+		plan.Dir, plan.Alias = alias.downloadDir(req.AliasName), req.AliasName
+	}
+	if plan.HFCache != nil {
+		plan.Dir = plan.HFCache.snapshotDir() // Downloaded in place, then moved into blobs by hfCache.finalize
+	}
+	return plan, nil
+}
+
+// hfDownloadDir is the default download directory of a repository,
+// downloads/<owner>_<repo>.
+func hfDownloadDir(repo hfRepo) string {
+	repoDirName := strings.ReplaceAll(strings.ReplaceAll(repo.ID, "..", ""), "/", "_")
+	if repo.Type != hfRepoTypeModel {
+		repoDirName = repo.Type + "s_" + repoDirName // Keep e.g. a dataset apart from a model of the same name
+	}
+	return filepath.Join("downloads", repoDirName)
+}
+
+// confirmDownloadAll asks before a whole repository is downloaded because it has
+// nothing to select.
+func confirmDownloadAll(files []HFFile) bool {
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	fmt.Fprintf(os.Stderr, "Download all %d file(s) of the repository (%s)? [y/N]: ", len(files), formatBytes(total))
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	if answer != "y" && answer != "yes" {
+		fmt.Fprintln(os.Stderr, "[INFO] Nothing downloaded.")
+		return false
+	}
+	return true
+}
+
+// runDownloads pre-scans the sizes, records the manifest, downloads every item of
+// the plan with the progress display and validates the result. It returns the exit
+// code.
+func runDownloads(plan *downloadPlan, effectiveConcurrency int, hfToken string) int {
+	finalDownloadItems, downloadDir, hfCache := plan.Items, plan.Dir, plan.HFCache
 	if len(finalDownloadItems) == 0 {
 		appLogger.Println("No URLs to download. Exiting.")
 		fmt.Fprintln(os.Stderr, "[INFO] No URLs to download. Exiting.")
@@ -728,21 +965,40 @@ func runActual() int {
 			preScanSem <- struct{}{}
 			defer func() { <-preScanSem }()
 			actualFile := generateActualFilename(dItem.URL, dItem.PreferredFilename)
-			initialSize, ok := hfFileSizes[dItem.URL]
+			initialSize, ok := plan.FileSizes[dItem.URL]
 			if !ok || initialSize == -1 {
 				var fetchErr error
-				initialSize, fetchErr = fetchSingleFileSize(dItem.URL, activeHuggingFaceToken)
+				initialSize, fetchErr = fetchSingleFileSize(dItem.URL, hfToken)
 				if fetchErr != nil {
 					appLogger.Printf("[PreScan] Error fetching size for %s: %v. Size will be unknown.", dItem.URL, fetchErr)
 					initialSize = -1
 				}
 			}
 			allPWs[idx] = newProgressWriter(idx, dItem.URL, actualFile, initialSize, manager)
+			allPWs[idx].ExpectedSHA256 = dItem.ExpectedSHA256
+			allPWs[idx].BlobID = dItem.BlobID
+			allPWs[idx].Replace = dItem.Replace
 		}(i, item)
 	}
 	preScanWG.Wait()
 	fmt.Fprintln(os.Stderr, "[INFO] Pre-scan complete.")
 
+	// The hub cache is content-addressed and keyed by commit, so it needs no manifest;
+	// one in the snapshot would also show up as a repository file to other tools.
+	if hfCache == nil {
+		markChangedFiles(downloadDir, allPWs)
+		if recErr := recordDownloads(downloadDir, allPWs); recErr != nil {
+			appLogger.Printf("[Main] Failed to write manifest in '%s': %v", downloadDir, recErr)
+			fmt.Fprintf(os.Stderr, "[WARN] Could not record download metadata in '%s': %v\n", downloadDir, recErr)
+		}
+	}
+	if (plan.CommitSHA != "" || plan.Alias != "") && hfCache == nil {
+		if recErr := recordSource(downloadDir, plan); recErr != nil {
+			appLogger.Printf("[Main] Failed to record the source in '%s': %v", downloadDir, recErr)
+			fmt.Fprintf(os.Stderr, "[WARN] Could not record the source of the downloads in '%s': %v\n", downloadDir, recErr)
+		}
+	}
+
 	manager.AddInitialDownloads(allPWs)
 
 	appLogger.Printf("Downloading %d file(s) to '%s' (concurrency: %d).", len(finalDownloadItems), downloadDir, effectiveConcurrency)
@@ -754,15 +1010,52 @@ func runActual() int {
 			continue
 		}
 		dlSem <- struct{}{}
+		if !startDownloadWorker() {
+			<-dlSem
+			break // Shutting down: the remaining files stay queued
+		}
 		dlWG.Add(1)
 		go func(pWriter *ProgressWriter) {
 			defer func() { <-dlSem }()
-			downloadFile(pWriter, &dlWG, downloadDir, manager, activeHuggingFaceToken)
+			defer downloadWorkers.Done()
+			downloadFile(pWriter, &dlWG, downloadDir, manager, hfToken)
 		}(pw)
 	}
 	dlWG.Wait()
 	appLogger.Println("All downloads processed.")
-	return 0
+	manager.Stop() // Final render before the summaries below
+	if hfCache != nil {
+		hfCache.finalize(allPWs)
+	} else if recErr := recordCompletions(downloadDir, allPWs); recErr != nil {
+		appLogger.Printf("[Main] Failed to record completed downloads in '%s': %v", downloadDir, recErr)
+		fmt.Fprintf(os.Stderr, "[WARN] Could not record the completed downloads in '%s': %v\n", downloadDir, recErr)
+	}
+	exitCode := 0
+	splitSeries := validateGGUFSplits(downloadDir, allPWs)
+	if mergeSplits && len(splitSeries) == 0 {
+		fmt.Fprintln(os.Stderr, "[INFO] -merge: no split GGUF files were downloaded.")
+	} else if mergeSplits && !mergeValidatedSplits(downloadDir, splitSeries) {
+		exitCode = 1
+	}
+	validateSafetensorsDownload(downloadDir, plan.SafetensorsIndexes, allPWs)
+	reportHFAccessFailures(allPWs, hfToken)
+	return exitCode
+}
+
+// findFlagValue returns the value of a string flag given anywhere in args as
+// -name v, --name v, -name=v or --name=v, or "" if it is not given.
+func findFlagValue(args []string, name string) string {
+	value := ""
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimPrefix(strings.TrimPrefix(args[i], "-"), "-")
+		if arg == name && i+1 < len(args) && len(arg) < len(args[i]) {
+			value = args[i+1]
+			i++
+		} else if strings.HasPrefix(arg, name+"=") && len(arg) < len(args[i]) {
+			value = strings.TrimPrefix(arg, name+"=")
+		}
+	}
+	return value
 }
 
 func logFileIsOpen() bool {
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestFileName is written into each download directory and records the expected
+// metadata of every file downloaded there, so the directory can be verified later.
+const manifestFileName = ".dl_manifest.json"
+
+// ManifestFile is the recorded metadata for one downloaded file.
+type ManifestFile struct {
+	URL         string     `json:"url"`
+	Size        int64      `json:"size,omitempty"`         // Expected size in bytes, 0 if unknown
+	SHA256      string     `json:"sha256,omitempty"`       // Expected sha256 (Hugging Face LFS files), else hashed once complete
+	BlobID      string     `json:"blob_id,omitempty"`      // Git blob id (Hugging Face files), compared by 'dl sync'
+	ETag        string     `json:"etag,omitempty"`         // ETag of the response the file was downloaded with
+	CompletedAt *time.Time `json:"completed_at,omitempty"` // When the download completed, nil while it has not
+}
+
+// DownloadManifest is the content of a download directory's manifest file.
+type DownloadManifest struct {
+	Alias     string                  `json:"alias,omitempty"`     // -m alias the directory was downloaded for
+	Repo      string                  `json:"repo,omitempty"`      // Hugging Face repository, for -hf downloads
+	Revision  string                  `json:"revision,omitempty"`  // Revision as requested (branch, tag, PR ref or sha)
+	Commit    string                  `json:"commit,omitempty"`    // Commit sha the revision resolved to
+	Selection *DownloadSelection      `json:"selection,omitempty"` // How the files of an -hf download were picked
+	Files     map[string]ManifestFile `json:"files"`               // Keyed by slash-separated path relative to the download directory
+}
+
+// DownloadSelection records how the files of an -hf download were picked from the
+// repository, so 'dl sync' only adds new files the same selection would pick.
+type DownloadSelection struct {
+	Files   []string `json:"files,omitempty"`   // Globs of the -m alias
+	Include []string `json:"include,omitempty"` // -include
+	Exclude []string `json:"exclude,omitempty"` // -exclude
+	Picked  string   `json:"picked,omitempty"`  // "gguf" (menu or -quant) or "shards": a hand-picked subset
+}
+
+// admits reports whether a file that is new in the repository belongs to the selection.
+func (s *DownloadSelection) admits(name string) bool {
+	if s.Picked != "" {
+		return false // Picking again is up to the user
+	}
+	if len(s.Files) > 0 && !(fileFilter{Include: s.Files}).matches(name) {
+		return false
+	}
+	return fileFilter{Include: s.Include, Exclude: s.Exclude}.matches(name)
+}
+
+func manifestPath(dir string) string { return filepath.Join(dir, manifestFileName) }
+
+// loadManifest reads the manifest of dir. A missing manifest yields an empty one.
+func loadManifest(dir string) (*DownloadManifest, error) {
+	manifest := &DownloadManifest{Files: make(map[string]ManifestFile)}
+	data, err := os.ReadFile(manifestPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]ManifestFile)
+	}
+	return manifest, nil
+}
+
+// save writes the manifest into dir, replacing the previous one atomically.
+func (m *DownloadManifest) save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := manifestPath(dir) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, manifestPath(dir))
+}
+
+// recordDownloads merges the expected metadata of the given progress writers into
+// the manifest of downloadDir.
+func recordDownloads(downloadDir string, pws []*ProgressWriter) error {
+	manifest, err := loadManifest(downloadDir)
+	if err != nil {
+		return err
+	}
+	for _, pw := range pws {
+		if pw == nil {
+			continue
+		}
+		pw.mu.Lock()
+		entry := ManifestFile{URL: pw.URL, SHA256: pw.ExpectedSHA256, BlobID: pw.BlobID}
+		if pw.Total > 0 {
+			entry.Size = pw.Total
+		}
+		key := filepath.ToSlash(pw.ActualFileName)
+		pw.mu.Unlock()
+		if old, ok := manifest.Files[key]; ok && pw.Replace {
+			// The entry keeps describing the outdated file on disk until the new one
+			// replaces it, so an interrupted replacement is detected again next time.
+			manifest.Files[key] = old
+			continue
+		}
+		// What is known about an unchanged file that is already complete is kept.
+		if old, ok := manifest.Files[key]; ok && old.URL == entry.URL && (entry.SHA256 == "" || strings.EqualFold(old.SHA256, entry.SHA256)) {
+			entry.SHA256, entry.ETag, entry.CompletedAt = old.SHA256, old.ETag, old.CompletedAt
+		}
+		manifest.Files[key] = entry
+	}
+	return manifest.save(downloadDir)
+}
+
+// recordSource stores where the files of downloadDir come from: the -m alias, and
+// for -hf downloads the repository commit and how the files were selected.
+func recordSource(downloadDir string, plan *downloadPlan) error {
+	manifest, err := loadManifest(downloadDir)
+	if err != nil {
+		return err
+	}
+	if plan.Alias != "" {
+		manifest.Alias = plan.Alias
+	}
+	if plan.Selection != nil {
+		manifest.Selection = plan.Selection
+	}
+	if plan.CommitSHA != "" {
+		if manifest.Commit != "" && manifest.Commit != plan.CommitSHA {
+			appLogger.Printf("[Manifest] %s moves from commit %s to %s.", downloadDir, manifest.Commit, plan.CommitSHA)
+		}
+		manifest.Repo, manifest.Revision, manifest.Commit = plan.RepoID, plan.Revision, plan.CommitSHA
+	}
+	return manifest.save(downloadDir)
+}
+
+// recordCompletions stores the ETag, completion time and sha256 of every file that
+// is complete after the downloads. Files without a published sha256 (plain URLs and
+// regular git files) get the one computed while streaming; for the rest 'verify'
+// hashes them once and records it.
+func recordCompletions(downloadDir string, pws []*ProgressWriter) error {
+	manifest, err := loadManifest(downloadDir)
+	if err != nil {
+		return err
+	}
+	for _, pw := range pws {
+		if pw == nil {
+			continue
+		}
+		pw.mu.Lock()
+		failed, etag, completedAt, streamedSHA256 := pw.ErrorMsg != "" || !pw.IsFinished, pw.ETag, pw.CompletedAt, pw.SHA256
+		key := filepath.ToSlash(pw.ActualFileName)
+		pw.mu.Unlock()
+		entry, ok := manifest.Files[key]
+		if !ok {
+			continue
+		}
+		filePath := filepath.Join(downloadDir, pw.ActualFileName)
+		info, statErr := os.Stat(filePath)
+		if statErr != nil {
+			entry.CompletedAt = nil
+			manifest.Files[key] = entry
+			continue
+		}
+		if failed {
+			continue
+		}
+		if !completedAt.IsZero() {
+			// Downloaded in this run, possibly replacing an outdated file.
+			entry.URL, entry.SHA256, entry.BlobID = pw.URL, pw.ExpectedSHA256, pw.BlobID
+			if entry.SHA256 == "" {
+				entry.SHA256 = streamedSHA256 // Empty for segmented downloads
+			}
+			entry.ETag, entry.CompletedAt, entry.Size = etag, &completedAt, info.Size()
+		} else if entry.CompletedAt == nil {
+			modTime := info.ModTime() // Complete before this run, e.g. by an older version
+			entry.CompletedAt = &modTime
+		}
+		if entry.Size <= 0 {
+			entry.Size = info.Size()
+		}
+		manifest.Files[key] = entry
+	}
+	return manifest.save(downloadDir)
+}
+
+// markChangedFiles marks completed files whose content changed since they were
+// recorded for replacement, because downloadFile otherwise treats a file of the
+// expected size as complete. Only the sha256 or the git blob id tell; the URL moves
+// with every commit of the revision, so a different URL alone changes nothing.
+func markChangedFiles(downloadDir string, pws []*ProgressWriter) {
+	manifest, err := loadManifest(downloadDir)
+	if err != nil {
+		appLogger.Printf("[Manifest] Could not read manifest of %s: %v", downloadDir, err)
+		return
+	}
+	for _, pw := range pws {
+		if pw == nil {
+			continue
+		}
+		old, ok := manifest.Files[filepath.ToSlash(pw.ActualFileName)]
+		if !ok {
+			continue
+		}
+		changed := false
+		switch {
+		case old.SHA256 != "" && pw.ExpectedSHA256 != "":
+			changed = !strings.EqualFold(old.SHA256, pw.ExpectedSHA256)
+		case old.BlobID != "" && pw.BlobID != "":
+			changed = old.BlobID != pw.BlobID
+		}
+		if !changed {
+			continue
+		}
+		filePath := filepath.Join(downloadDir, pw.ActualFileName)
+		if _, statErr := os.Stat(filePath); statErr != nil {
+			continue
+		}
+		appLogger.Printf("[Manifest] %s changed (was %s, sha256 %s). Downloading it again to replace it.", filePath, old.URL, old.SHA256)
+		fmt.Fprintf(os.Stderr, "[INFO] %s changed since it was downloaded; downloading it again.\n", pw.ActualFileName)
+		pw.Replace = true
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// fitContextLength is the context size the memory-fit estimate assumes (capped at
+// the model's trained context), set by main.go via -ctx.
+var fitContextLength = 8192
+
+// fitComputeBuffer approximates llama.cpp's compute buffers and runtime overhead.
+const fitComputeBuffer = 512 << 20
+
+// nvidiaGPU is one GPU as reported by nvidia-smi.
+type nvidiaGPU struct {
+	Name      string
+	TotalMiB  int64
+	FreeMiB   int64
+	FreeKnown bool
+}
+
+// queryNvidiaGPUs lists the NVIDIA GPUs with their total and free memory.
+func queryNvidiaGPUs() ([]nvidiaGPU, error) {
+	output, err := exec.Command("nvidia-smi", "--query-gpu=gpu_name,memory.total,memory.free", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, err
+	}
+	var gpus []nvidiaGPU
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.Split(line, ",")
+		if len(parts) < 2 {
+			continue
+		}
+		gpu := nvidiaGPU{Name: strings.TrimSpace(parts[0])}
+		gpu.TotalMiB, _ = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64) // Already in MiB
+		if len(parts) > 2 {
+			if free, err := strconv.ParseInt(strings.TrimSpace(parts[2]), 10, 64); err == nil {
+				gpu.FreeMiB, gpu.FreeKnown = free, true
+			}
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+// systemMemory is what a model can be loaded into.
+type systemMemory struct {
+	AvailableRAM int64  // 0 if unknown
+	VRAM         int64  // Free memory of all NVIDIA GPUs together, 0 if none
+	GPUNames     string // For display
+	Unified      bool   // Apple Silicon: the GPU uses (most of) the RAM
+}
+
+// detectSystemMemory reads the available RAM with gopsutil and the free VRAM with
+// nvidia-smi. On Apple Silicon, Metal can use about three quarters of the RAM.
+func detectSystemMemory() systemMemory {
+	var sys systemMemory
+	if vmStat, err := mem.VirtualMemory(); err == nil {
+		sys.AvailableRAM = int64(vmStat.Available)
+		if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
+			sys.Unified = true
+			sys.VRAM = int64(vmStat.Total) / 4 * 3
+			if sys.VRAM > sys.AvailableRAM {
+				sys.VRAM = sys.AvailableRAM
+			}
+			sys.GPUNames = "Apple Silicon, unified memory"
+		}
+	} else {
+		appLogger.Printf("[MemFit] Error fetching RAM info: %v", err)
+	}
+	if sys.Unified {
+		return sys
+	}
+	gpus, err := queryNvidiaGPUs()
+	if err != nil {
+		appLogger.Printf("[MemFit] nvidia-smi not available: %v", err)
+		return sys
+	}
+	var names []string
+	for _, gpu := range gpus {
+		mib := gpu.TotalMiB
+		if gpu.FreeKnown {
+			mib = gpu.FreeMiB
+		}
+		sys.VRAM += mib << 20
+		names = append(names, gpu.Name)
+	}
+	sys.GPUNames = strings.Join(names, ", ")
+	return sys
+}
+
+func (sys systemMemory) String() string {
+	parts := []string{}
+	if sys.AvailableRAM > 0 {
+		parts = append(parts, formatBytes(sys.AvailableRAM)+" RAM available")
+	}
+	if sys.VRAM > 0 {
+		parts = append(parts, fmt.Sprintf("%s VRAM free (%s)", formatBytes(sys.VRAM), sys.GPUNames))
+	} else {
+		parts = append(parts, "no GPU memory detected")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// kvCacheBytes estimates the f16 KV cache of a model at a context length from its
+// GGUF header, or returns 0 if the header lacks the needed keys.
+func kvCacheBytes(md *GGUFMetadata, contextLength int) int64 {
+	if md == nil {
+		return 0
+	}
+	layers := md.ArchUint("block_count")
+	headCount := md.ArchUint("attention.head_count")
+	headCountKV := md.ArchUint("attention.head_count_kv")
+	if headCountKV == 0 {
+		headCountKV = headCount
+	}
+	keyLength := md.ArchUint("attention.key_length")
+	valueLength := md.ArchUint("attention.value_length")
+	if keyLength == 0 || valueLength == 0 {
+		embedding := md.ArchUint("embedding_length")
+		if headCount == 0 || embedding == 0 {
+			return 0
+		}
+		keyLength, valueLength = embedding/headCount, embedding/headCount
+	}
+	if layers == 0 || headCountKV == 0 {
+		return 0
+	}
+	ctx := uint64(contextLength)
+	if trained := md.ArchUint("context_length"); trained > 0 && trained < ctx {
+		ctx = trained
+	}
+	return int64(layers * ctx * headCountKV * (keyLength + valueLength) * 2)
+}
+
+// Memory-fit verdicts of memoryFit.
+const (
+	fitUnknown = iota
+	fitVRAM
+	fitRAMAndVRAM
+	fitRAM
+	fitTooLarge
+)
+
+// memoryFit estimates what a model file of the given size needs at
+// fitContextLength and where it fits.
+func memoryFit(size int64, md *GGUFMetadata, sys systemMemory) (verdict int, need int64, kv int64) {
+	if size <= 0 {
+		return fitUnknown, 0, 0
+	}
+	kv = kvCacheBytes(md, fitContextLength)
+	overhead := kv
+	if overhead == 0 { // No header: assume a modest context
+		overhead = size / 10
+	}
+	need = size + overhead + fitComputeBuffer
+	switch {
+	case sys.VRAM > 0 && need <= sys.VRAM:
+		return fitVRAM, need, kv
+	case sys.Unified:
+		if sys.AvailableRAM > 0 && need <= sys.AvailableRAM {
+			return fitRAM, need, kv
+		}
+	case sys.AvailableRAM > 0 && need <= sys.AvailableRAM:
+		return fitRAM, need, kv
+	case sys.VRAM > 0 && sys.AvailableRAM > 0 && need <= sys.AvailableRAM+sys.VRAM:
+		return fitRAMAndVRAM, need, kv
+	}
+	if sys.AvailableRAM == 0 && sys.VRAM == 0 {
+		return fitUnknown, need, kv
+	}
+	return fitTooLarge, need, kv
+}
+
+func fitLabel(verdict int, sys systemMemory) string {
+	switch verdict {
+	case fitVRAM:
+		if sys.Unified {
+			return "fits in GPU (unified memory)"
+		}
+		return "fits in VRAM"
+	case fitRAMAndVRAM:
+		return "fits in RAM+VRAM (partial offload)"
+	case fitRAM:
+		return "fits in RAM"
+	case fitTooLarge:
+		return "too large"
+	}
+	return "fit unknown"
+}
+
+// annotateMemoryFit sets the memory-fit line of every item.
+func annotateMemoryFit(items []SelectableGGUFItem, sys systemMemory) {
+	fmt.Fprintf(os.Stderr, "[INFO] Memory: %s. Estimates include the KV cache for a %d-token context (-ctx).\n", sys, fitContextLength)
+	for i := range items {
+		verdict, need, kv := memoryFit(items[i].TotalSize, items[i].Metadata, sys)
+		items[i].fit = verdict
+		if need == 0 {
+			continue
+		}
+		detail := fmt.Sprintf("needs ~%s", formatBytes(need))
+		if kv > 0 {
+			detail += fmt.Sprintf(" incl. %s KV cache", formatBytes(kv))
+		}
+		items[i].FitNote = fmt.Sprintf("%s (%s)", fitLabel(verdict, sys), detail)
+	}
+}
+
+// selectGGUFAuto picks the largest complete file or series that fits, preferring
+// those that fit entirely in VRAM. Within one repository a larger quantization of
+// the same model is the higher-quality one. Multimodal projectors are skipped.
+func selectGGUFAuto(items []SelectableGGUFItem) (int, error) {
+	var candidates []int
+	for i, item := range items {
+		if (item.IsSeries && !item.IsComplete) || item.TotalSize <= 0 || len(item.FilesToDownload) == 0 {
+			continue
+		}
+		if strings.Contains(strings.ToLower(item.FilesToDownload[0].Filename), "mmproj") {
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return items[candidates[a]].TotalSize > items[candidates[b]].TotalSize })
+	for _, tier := range [][]int{{fitVRAM}, {fitRAM, fitRAMAndVRAM}} {
+		for _, idx := range candidates {
+			for _, wanted := range tier {
+				if items[idx].fit == wanted {
+					return idx, nil
+				}
+			}
+		}
+	}
+	for _, idx := range candidates {
+		if items[idx].fit == fitUnknown {
+			return -1, fmt.Errorf("-quant auto: available memory could not be determined, choose a quantization with -quant <tag>")
+		}
+	}
+	if len(candidates) == 0 {
+		return -1, fmt.Errorf("-quant auto: no complete GGUF file or series with a known size")
+	}
+	smallest := items[candidates[len(candidates)-1]]
+	return -1, fmt.Errorf("-quant auto: no quantization fits into the available memory (smallest: %s, %s)", smallest.DisplayName, smallest.FitNote)
+}
+
+// hasAutoQuant reports whether -quant auto was given.
+func hasAutoQuant(patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.EqualFold(pattern, "auto") {
+			return true
+		}
+	}
+	return false
+}
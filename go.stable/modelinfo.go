@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// modelInfoFile is one file of the listing in 'model info'.
+type modelInfoFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"` // 0 if unknown
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// modelInfoGroup sums the files of one directory or file type.
+type modelInfoGroup struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// modelInfoGGUF is a GGUF file or split series as offered by -select.
+type modelInfoGGUF struct {
+	Name     string   `json:"name"`
+	Quant    string   `json:"quant,omitempty"`
+	Files    []string `json:"files"`
+	Size     int64    `json:"size"`
+	Complete bool     `json:"complete"`
+}
+
+// modelInfoShardSet is a safetensors index and the shards it references.
+type modelInfoShardSet struct {
+	Index   string   `json:"index"`
+	Shards  int      `json:"shards"`
+	Tensors int      `json:"tensors"`
+	Size    int64    `json:"size"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+// modelInfo is what 'model info' prints; with --json as is.
+type modelInfo struct {
+	Repo      string   `json:"repo"`
+	RepoType  string   `json:"repo_type"`
+	Revision  string   `json:"revision"`
+	License   string   `json:"license,omitempty"`
+	BaseModel []string `json:"base_model,omitempty"`
+	*HFApiModelInfo
+	Files       []modelInfoFile     `json:"files"`
+	Directories []modelInfoGroup    `json:"directories"`
+	Types       []modelInfoGroup    `json:"types"`
+	GGUF        []modelInfoGGUF     `json:"gguf,omitempty"`
+	Safetensors []modelInfoShardSet `json:"safetensors,omitempty"`
+	TotalSize   int64               `json:"total_size"`
+	UnknownSize int                 `json:"unknown_size_files,omitempty"`
+}
+
+// HandleModelInfo handles 'model info <owner/repo>': the card metadata, the file tree
+// grouped by directory and type, the GGUF files and series -select would offer, the
+// safetensors shard sets and the total download size of a repository revision.
+func HandleModelInfo(args []string, hfToken string) bool {
+	infoFlags := flag.NewFlagSet("model info", flag.ContinueOnError)
+	infoFlags.SetOutput(os.Stderr)
+	jsonOutput := infoFlags.Bool("json", false, "Print the repository details as JSON")
+	revision := infoFlags.String("revision", defaultHFRevision, "Branch, tag or commit to show")
+	repoType := infoFlags.String("repo-type", "", "Repository type: model (default), dataset or space")
+	var target string
+	for len(args) > 0 { // Flags may come before or after the repository
+		if err := infoFlags.Parse(args); err != nil {
+			return false
+		}
+		args = infoFlags.Args()
+		if len(args) > 0 {
+			if target != "" {
+				fmt.Fprintln(os.Stderr, "Error: 'model info' takes one repository.")
+				return false
+			}
+			target, args = args[0], args[1:]
+		}
+	}
+	if target == "" {
+		fmt.Fprintln(os.Stderr, "Error: Missing <owner/repo> for 'model info'.")
+		return false
+	}
+	if *repoType != "" && !isValidHFRepoType(*repoType) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -repo-type '%s'. Expected model, dataset or space.\n", *repoType)
+		return false
+	}
+	repo, err := parseHuggingFaceRepo(target, *repoType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false
+	}
+
+	info, err := collectModelInfo(repo, *revision, hfToken)
+	if err != nil {
+		appLogger.Printf("[ModelInfo] %s: %v", repo, err)
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not encode JSON: %v\n", err)
+			return false
+		}
+		return true
+	}
+	printModelInfo(info)
+	return true
+}
+
+// collectModelInfo fetches the repo info of the revision and its file listing.
+func collectModelInfo(repo hfRepo, revision string, hfToken string) (*modelInfo, error) {
+	apiURL := repo.apiURL("revision/" + url.PathEscape(revision))
+	appLogger.Printf("[ModelInfo] Fetching repo info of %s@%s via %s", repo, revision, apiURL)
+	status, body, err := hfAPIGet(apiURL, hfToken)
+	if err != nil {
+		return nil, err
+	}
+	if msg := hfAccessMessage(repo, status, hfToken != ""); msg != "" {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("repo info request to %s failed with status %d: %s", apiURL, status, strings.TrimSpace(string(body)))
+	}
+	var apiInfo HFApiModelInfo
+	if err := json.Unmarshal(body, &apiInfo); err != nil {
+		return nil, fmt.Errorf("error decoding repo info of %s: %w", repo, err)
+	}
+
+	files, commitSHA, err := fetchHuggingFaceURLs(repo, revision, hfToken)
+	if err != nil {
+		return nil, err
+	}
+	if apiInfo.SHA == "" {
+		apiInfo.SHA = commitSHA
+	}
+	apiInfo.Siblings = nil // The listing below has them with sizes
+
+	info := &modelInfo{Repo: repo.ID, RepoType: repo.Type, Revision: revision, HFApiModelInfo: &apiInfo}
+	info.License, info.BaseModel = cardLicenseAndBaseModel(&apiInfo)
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+	dirs := make(map[string]*modelInfoGroup)
+	types := make(map[string]*modelInfoGroup)
+	fileSizes := make(map[string]int64)
+	for _, file := range files {
+		info.Files = append(info.Files, modelInfoFile{Path: file.Filename, Size: file.Size, SHA256: file.SHA256})
+		info.TotalSize += file.Size
+		if file.Size > 0 {
+			fileSizes[file.URL] = file.Size
+		} else {
+			info.UnknownSize++
+		}
+		addToModelInfoGroup(dirs, path.Dir(file.Filename), file.Size)
+		addToModelInfoGroup(types, modelInfoType(file.Filename), file.Size)
+	}
+	info.Directories = sortedModelInfoGroups(dirs, func(a, b modelInfoGroup) bool {
+		if (a.Name == ".") != (b.Name == ".") {
+			return a.Name == "." // Top level first
+		}
+		return a.Name < b.Name
+	})
+	info.Types = sortedModelInfoGroups(types, func(a, b modelInfoGroup) bool {
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Name < b.Name
+	})
+
+	for _, item := range buildGGUFSelection(files, fileSizes, hfConcurrency(0, false), hfToken) {
+		gguf := modelInfoGGUF{Name: item.DisplayName, Quant: item.QuantTag, Size: item.TotalSize, Complete: item.IsComplete}
+		for _, file := range item.FilesToDownload {
+			gguf.Files = append(gguf.Files, file.Filename)
+		}
+		info.GGUF = append(info.GGUF, gguf)
+	}
+	for _, idx := range loadSafetensorsIndexes(files, hfToken) {
+		set := modelInfoShardSet{Index: idx.Path, Shards: len(idx.shards), Tensors: len(idx.WeightMap), Missing: idx.missing}
+		for _, size := range idx.sizes {
+			set.Size += size
+		}
+		info.Safetensors = append(info.Safetensors, set)
+	}
+	return info, nil
+}
+
+// cardLicenseAndBaseModel reads the license and base model(s) from the model card,
+// falling back to the license: and base_model: tags the Hub derives from it.
+func cardLicenseAndBaseModel(apiInfo *HFApiModelInfo) (string, []string) {
+	var license string
+	var baseModels []string
+	if value, ok := apiInfo.CardData["license"].(string); ok {
+		license = value
+	}
+	switch value := apiInfo.CardData["base_model"].(type) {
+	case string:
+		baseModels = []string{value}
+	case []interface{}:
+		for _, element := range value {
+			if name, ok := element.(string); ok {
+				baseModels = append(baseModels, name)
+			}
+		}
+	}
+	for _, tag := range apiInfo.Tags {
+		if name, ok := strings.CutPrefix(tag, "license:"); ok && license == "" {
+			license = name
+		}
+		// base_model:Qwen/Qwen3-8B, or base_model:quantized:Qwen/Qwen3-8B for the relation
+		if name, ok := strings.CutPrefix(tag, "base_model:"); ok && len(baseModels) == 0 && !strings.Contains(name, ":") {
+			baseModels = append(baseModels, name)
+		}
+	}
+	return license, baseModels
+}
+
+// modelInfoType groups a file by extension; safetensors indexes and split GGUF
+// parts are grouped with their kind.
+func modelInfoType(filename string) string {
+	lower := strings.ToLower(filename)
+	if strings.HasSuffix(lower, ".safetensors.index.json") {
+		return ".safetensors.index.json"
+	}
+	if ext := path.Ext(lower); ext != "" {
+		return ext
+	}
+	return "(no extension)"
+}
+
+func addToModelInfoGroup(groups map[string]*modelInfoGroup, name string, size int64) {
+	group, ok := groups[name]
+	if !ok {
+		group = &modelInfoGroup{Name: name}
+		groups[name] = group
+	}
+	group.Files++
+	group.Size += size
+}
+
+func sortedModelInfoGroups(groups map[string]*modelInfoGroup, less func(a, b modelInfoGroup) bool) []modelInfoGroup {
+	sorted := make([]modelInfoGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, *group)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// printModelInfo prints the details on stdout, each file indented below its
+// directory.
+func printModelInfo(info *modelInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Repository:\t%s (%s)\n", info.Repo, info.RepoType)
+	revision := info.SHA
+	if info.Revision != info.SHA {
+		revision = fmt.Sprintf("%s (%s)", info.SHA, info.Revision)
+	}
+	fmt.Fprintf(tw, "Commit:\t%s\n", revision)
+	if !info.LastModified.IsZero() {
+		fmt.Fprintf(tw, "Last modified:\t%s\n", info.LastModified.Format("2006-01-02 15:04 MST"))
+	}
+	printModelInfoField(tw, "License", info.License)
+	printModelInfoField(tw, "Pipeline", info.PipelineTag)
+	printModelInfoField(tw, "Library", info.LibraryName)
+	printModelInfoField(tw, "Base model", strings.Join(info.BaseModel, ", "))
+	gated := "no"
+	if isGated, mode := hfIsGated(info.Gated); isGated {
+		gated = "yes"
+		if mode != "" {
+			gated += " (approval: " + mode + ")"
+		}
+	}
+	fmt.Fprintf(tw, "Gated:\t%s\n", gated)
+	if info.Private {
+		fmt.Fprintf(tw, "Private:\tyes\n")
+	}
+	if info.Downloads > 0 || info.Likes > 0 {
+		fmt.Fprintf(tw, "Downloads / likes:\t%s / %s\n", formatNumber(info.Downloads), formatNumber(info.Likes))
+	}
+	tw.Flush()
+
+	fmt.Printf("\nFiles (%d):\n", len(info.Files))
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, dir := range info.Directories {
+		if dir.Name != "." {
+			fmt.Fprintf(tw, "  %s/\t%s\n", dir.Name, info.formatSize(dir.Size))
+		}
+		indent := "  "
+		if dir.Name != "." {
+			indent = "    "
+		}
+		for _, file := range info.Files {
+			if path.Dir(file.Path) == dir.Name {
+				fmt.Fprintf(tw, "%s%s\t%s\n", indent, path.Base(file.Path), info.formatSize(file.Size))
+			}
+		}
+	}
+	tw.Flush()
+
+	fmt.Println("\nBy type:")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, group := range info.Types {
+		fmt.Fprintf(tw, "  %s\t%d file(s)\t%s\n", group.Name, group.Files, info.formatSize(group.Size))
+	}
+	tw.Flush()
+
+	if len(info.GGUF) > 0 {
+		fmt.Printf("\nGGUF files and series (%d):\n", len(info.GGUF))
+		for i, gguf := range info.GGUF {
+			fmt.Printf("%3d. %s\n", i+1, gguf.Name)
+		}
+	}
+	if len(info.Safetensors) > 0 {
+		fmt.Println("\nSafetensors shard sets:")
+		for _, set := range info.Safetensors {
+			fmt.Printf("  %s: %d tensors in %d shard(s), %s", set.Index, set.Tensors, set.Shards, formatBytes(set.Size))
+			if len(set.Missing) > 0 {
+				fmt.Printf(" (%d shard(s) missing from the repository)", len(set.Missing))
+			}
+			fmt.Println()
+		}
+	}
+
+	total := formatBytes(info.TotalSize)
+	if info.UnknownSize > 0 {
+		total += fmt.Sprintf(" (%d file(s) of unknown size not counted)", info.UnknownSize)
+	}
+	fmt.Printf("\nTotal download size: %s\n", total)
+}
+
+func printModelInfoField(tw *tabwriter.Writer, label, value string) {
+	if value != "" {
+		fmt.Fprintf(tw, "%s:\t%s\n", label, value)
+	}
+}
+
+// formatSize shows "?" for a size the listing did not report; that only happens
+// when the basic listing without sizes had to be used.
+func (info *modelInfo) formatSize(size int64) string {
+	if size == 0 && info.UnknownSize > 0 {
+		return "?"
+	}
+	return formatBytes(size)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	partFileSuffix     = ".part"      // Downloads are written to <name>.part and renamed when complete
+	resumeRecordSuffix = ".part.json" // Sidecar describing what the .part file holds
+)
+
+// resumeRecord is persisted next to a .part file. It remembers which remote object
+// the partial bytes came from, so a resume can be refused with If-Range if the file
+// changed remotely, and for segmented downloads how far each segment got.
+type resumeRecord struct {
+	URL          string          `json:"url"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	Size         int64           `json:"size"`               // Expected size, -1 if unknown
+	Segments     []*segmentState `json:"segments,omitempty"` // Only for segmented downloads
+
+	mu   sync.Mutex
+	path string
+}
+
+func partFilePath(filePath string) string { return filePath + partFileSuffix }
+func resumeRecordPath(partPath string) string {
+	return strings.TrimSuffix(partPath, partFileSuffix) + resumeRecordSuffix
+}
+
+func newResumeRecord(partPath string, fileURL string, size int64) *resumeRecord {
+	return &resumeRecord{URL: fileURL, Size: size, path: resumeRecordPath(partPath)}
+}
+
+// loadResumeRecord reads the sidecar of partPath. It returns nil if there is none
+// or it cannot be used.
+func loadResumeRecord(partPath string) *resumeRecord {
+	recordPath := resumeRecordPath(partPath)
+	data, err := os.ReadFile(recordPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			appLogger.Printf("[PartFile] Could not read resume record '%s': %v", recordPath, err)
+		}
+		return nil
+	}
+	var record resumeRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		appLogger.Printf("[PartFile] Ignoring invalid resume record '%s': %v", recordPath, err)
+		return nil
+	}
+	record.path = recordPath
+	return &record
+}
+
+// save writes the record atomically. Callers must not hold r.mu.
+func (r *resumeRecord) save() error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmpPath := r.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.path)
+}
+
+func (r *resumeRecord) remove() {
+	if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+		appLogger.Printf("[PartFile] Could not remove resume record '%s': %v", r.path, err)
+	}
+}
+
+// reset forgets everything learned about the remote object, for a fresh start.
+func (r *resumeRecord) reset(size int64) {
+	r.mu.Lock()
+	r.ETag, r.LastModified, r.Size, r.Segments = "", "", size, nil
+	r.mu.Unlock()
+}
+
+// setValidators stores the ETag/Last-Modified of a response for later If-Range use.
+func (r *resumeRecord) setValidators(resp *http.Response) {
+	r.mu.Lock()
+	r.ETag = resp.Header.Get("ETag")
+	r.LastModified = resp.Header.Get("Last-Modified")
+	r.mu.Unlock()
+}
+
+// ifRange returns the value for an If-Range header. Weak ETags are not allowed
+// there, so Last-Modified is used instead when the ETag is weak.
+func (r *resumeRecord) ifRange() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// sameObject reports whether resp describes the object the record was made for.
+func (r *resumeRecord) sameObject(resp *http.Response) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if etag := resp.Header.Get("ETag"); r.ETag != "" && etag != "" {
+		return etag == r.ETag
+	}
+	if lm := resp.Header.Get("Last-Modified"); r.LastModified != "" && lm != "" {
+		return lm == r.LastModified
+	}
+	return true // Nothing to compare against
+}
+
+// discardPartFile removes a stale .part file together with its resume record.
+func discardPartFile(partPath string, record *resumeRecord) {
+	os.Remove(partPath)
+	if record != nil {
+		record.remove()
+	}
+}
+
+// finalizePartFile checks the size of a finished .part file and moves it to its
+// final name. A short file is reported as retryable so the next attempt resumes it.
+func finalizePartFile(pw *ProgressWriter, partPath string, filePath string, record *resumeRecord) error {
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return fmt.Errorf("Stat part file: %v", shortenError(err, 25))
+	}
+	pw.mu.Lock()
+	expected := pw.Total
+	pw.mu.Unlock()
+	if expected > 0 && info.Size() < expected {
+		return &retryableError{err: fmt.Errorf("Incomplete: got %s of %s", formatBytes(info.Size()), formatBytes(expected))}
+	}
+	if expected > 0 && info.Size() > expected {
+		discardPartFile(partPath, record)
+		return fmt.Errorf("Size mismatch: got %d bytes, expected %d", info.Size(), expected)
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		return fmt.Errorf("Rename: %v", shortenError(err, 25))
+	}
+	record.mu.Lock()
+	etag := record.ETag
+	record.mu.Unlock()
+	pw.mu.Lock()
+	pw.ETag, pw.CompletedAt = etag, time.Now()
+	pw.mu.Unlock()
+	record.remove()
+	return nil
+}
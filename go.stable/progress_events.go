@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Values for -progress.
+const (
+	progressModeAuto    = "auto"    // tty on an interactive terminal, summary otherwise
+	progressModeTTY     = "tty"     // Interactive ANSI bars, redrawn in place
+	progressModeSummary = "summary" // Periodic overall lines plus one line per finished file
+	progressModeJSON    = "json"    // Newline-delimited JSON events on stdout
+	progressModePlain   = "plain"   // One line per state change on stdout
+)
+
+// progressMode selects the renderer of new ProgressManagers, set by main.go via -progress.
+var progressMode = progressModeAuto
+
+// progressSummaryInterval is how often the summary renderer prints the overall state.
+const progressSummaryInterval = 10 * time.Second
+
+// progressEventTimeFormat is RFC 3339 with milliseconds, so events within a second keep their order.
+const progressEventTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// progressEventInterval limits how often a JSON progress event is emitted per file.
+const progressEventInterval = time.Second
+
+// progressRenderer turns the state held by the ProgressWriters of a ProgressManager
+// into output. ProgressWriters are the single source of truth; renderers only read them.
+type progressRenderer interface {
+	begin()                                                      // Called once before the first draw
+	draw(m *ProgressManager, bars []*ProgressWriter, final bool) // final is set for the last call
+	end(hadBars bool)                                            // Called once after the final draw
+}
+
+func isValidProgressMode(mode string) bool {
+	switch mode {
+	case progressModeAuto, progressModeTTY, progressModeSummary, progressModeJSON, progressModePlain:
+		return true
+	}
+	return false
+}
+
+// resolveProgressMode picks a concrete mode for auto: the redrawn display needs
+// stderr, where it draws, to be a terminal that understands cursor movement;
+// anything else gets summary lines so log files are not filled with escape sequences.
+func resolveProgressMode(mode string) string {
+	if mode != progressModeAuto {
+		return mode
+	}
+	if _, ok := terminalWidth(os.Stderr); !ok {
+		appLogger.Println("[Progress] stderr is not a terminal, using summary output.")
+		return progressModeSummary
+	}
+	if os.Getenv("TERM") == "dumb" {
+		appLogger.Println("[Progress] TERM=dumb, using summary output.")
+		return progressModeSummary
+	}
+	if _, set := os.LookupEnv("NO_COLOR"); set {
+		appLogger.Println("[Progress] NO_COLOR is set, using summary output.")
+		return progressModeSummary
+	}
+	return progressModeTTY
+}
+
+func newProgressRenderer(mode string) progressRenderer {
+	switch resolveProgressMode(mode) {
+	case progressModeJSON:
+		return newEventRenderer(true)
+	case progressModePlain:
+		return newEventRenderer(false)
+	case progressModeSummary:
+		r := newEventRenderer(false)
+		r.quiet, r.summaryInterval = true, progressSummaryInterval
+		return r
+	default:
+		return terminalRenderer{}
+	}
+}
+
+// progressSnapshot is a consistent copy of a ProgressWriter's state.
+type progressSnapshot struct {
+	ID          int
+	URL         string
+	File        string
+	Current     int64
+	Total       int64
+	Speed       float64
+	Started     bool
+	Finished    bool
+	Error       string
+	Retry       int
+	MaxRetries  int
+	RetryReason string
+}
+
+func (pw *ProgressWriter) snapshot() progressSnapshot {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return progressSnapshot{
+		ID: pw.id, URL: pw.URL, File: pw.ActualFileName, Current: pw.Current, Total: pw.Total,
+		Speed: pw.currentSpeedBps, Started: pw.Started, Finished: pw.IsFinished, Error: pw.ErrorMsg,
+		Retry: pw.Retry, MaxRetries: pw.MaxRetries, RetryReason: pw.RetryReason,
+	}
+}
+
+// etaSeconds returns the estimated seconds left, or -1 if unknown.
+func (s progressSnapshot) etaSeconds() float64 {
+	if s.Speed <= 0 || s.Total <= 0 || s.Current >= s.Total {
+		return -1
+	}
+	return float64(s.Total-s.Current) / s.Speed
+}
+
+// progressEvent is one line of the -progress=json stream.
+type progressEvent struct {
+	Event      string   `json:"event"` // queued, started, progress, retry, finished, error, summary
+	Time       string   `json:"time"`
+	ID         *int     `json:"id,omitempty"`
+	File       string   `json:"file,omitempty"`
+	URL        string   `json:"url,omitempty"`
+	Bytes      int64    `json:"bytes"`
+	Total      int64    `json:"total,omitempty"` // Omitted while the size is unknown
+	SpeedBps   *float64 `json:"speed_bps,omitempty"`
+	ETASeconds *float64 `json:"eta_seconds,omitempty"`
+	Retry      int      `json:"retry,omitempty"`
+	MaxRetries int      `json:"max_retries,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Files      int      `json:"files,omitempty"`      // summary only
+	Failed     *int     `json:"failed,omitempty"`     // summary only
+	Incomplete *int     `json:"incomplete,omitempty"` // summary only, files cut short by shutdown
+}
+
+// barEventState is what an eventRenderer has already reported for one file.
+type barEventState struct {
+	started, finished bool
+	retry             int
+	lastProgress      time.Time
+	lastBytes         int64
+}
+
+// eventRenderer reports state changes of the ProgressWriters as they are observed,
+// either as JSON events or as plain log lines. In summary mode it is quiet about
+// queued/started files and prints an overall line every summaryInterval instead.
+type eventRenderer struct {
+	json            bool
+	quiet           bool
+	summaryInterval time.Duration
+	lastSummary     time.Time
+	seen            map[int]*barEventState
+	out             *os.File // stdout for JSON, which is meant to be consumed; stderr otherwise
+	enc             *json.Encoder
+}
+
+func newEventRenderer(asJSON bool) *eventRenderer {
+	out := os.Stderr
+	if asJSON {
+		out = os.Stdout
+	}
+	return &eventRenderer{json: asJSON, seen: make(map[int]*barEventState), out: out, enc: json.NewEncoder(out), lastSummary: time.Now()}
+}
+
+func (r *eventRenderer) begin()           {}
+func (r *eventRenderer) end(hadBars bool) {}
+
+func (r *eventRenderer) draw(m *ProgressManager, bars []*ProgressWriter, final bool) {
+	stdoutMutex.Lock()
+	defer stdoutMutex.Unlock()
+
+	now := time.Now()
+	var totalBytes, expectedBytes int64
+	var speed float64
+	failed, incomplete := 0, 0
+	for _, bar := range bars {
+		s := bar.snapshot()
+		totalBytes += s.Current
+		if s.Total > 0 {
+			expectedBytes += s.Total
+		}
+		if !s.Finished {
+			speed += s.Speed
+		}
+		if s.Finished && s.Error != "" {
+			failed++
+		} else if !s.Finished {
+			incomplete++
+		}
+		st := r.seen[s.ID]
+		if st == nil {
+			st = &barEventState{}
+			r.seen[s.ID] = st
+			r.emit("queued", s)
+		}
+		if !st.started && (s.Started || s.Finished) {
+			st.started = true
+			st.lastProgress, st.lastBytes = now, s.Current
+			r.emit("started", s)
+		}
+		if s.Retry > st.retry {
+			st.retry = s.Retry
+			r.emit("retry", s)
+		}
+		if s.Finished {
+			if !st.finished {
+				st.finished = true
+				if s.Error != "" {
+					r.emit("error", s)
+				} else {
+					r.emit("finished", s)
+				}
+			}
+			continue
+		}
+		if r.json && st.started && s.Current != st.lastBytes && now.Sub(st.lastProgress) >= progressEventInterval {
+			st.lastProgress, st.lastBytes = now, s.Current
+			r.emit("progress", s)
+		}
+	}
+
+	if r.summaryInterval > 0 && !final && incomplete > 0 && now.Sub(r.lastSummary) >= r.summaryInterval {
+		r.lastSummary = now
+		line := fmt.Sprintf("%d/%d files, %s", len(bars)-incomplete, len(bars), formatBytes(totalBytes))
+		if expectedBytes > 0 {
+			line += fmt.Sprintf(" of %s (%.1f%%)", formatBytes(expectedBytes), float64(totalBytes)*100/float64(expectedBytes))
+		}
+		line += " @ " + strings.TrimSpace(formatSpeed(speed))
+		if speed > 0 && expectedBytes > totalBytes {
+			line += " ETA " + calculateETA(speed, expectedBytes, totalBytes, false)
+		}
+		if capStr := rateLimitSummary(); capStr != "" {
+			line += " (" + capStr + ")"
+		}
+		fmt.Fprintf(r.out, "%s %-8s %s\n", now.Format("15:04:05"), "progress", line)
+	}
+
+	if final && len(bars) > 0 {
+		if r.json {
+			r.enc.Encode(progressEvent{Event: "summary", Time: now.UTC().Format(progressEventTimeFormat), Bytes: totalBytes, Files: len(bars), Failed: &failed, Incomplete: &incomplete})
+		} else {
+			fmt.Fprintf(r.out, "%s %-8s %d file(s), %d failed, %d incomplete, %s\n", now.Format("15:04:05"), "done", len(bars), failed, incomplete, formatBytes(totalBytes))
+		}
+	}
+	r.out.Sync()
+}
+
+func (r *eventRenderer) emit(event string, s progressSnapshot) {
+	if r.quiet && (event == "queued" || event == "started") {
+		return
+	}
+	now := time.Now()
+	if r.json {
+		e := progressEvent{Event: event, Time: now.UTC().Format(progressEventTimeFormat), ID: &s.ID, File: s.File, URL: s.URL, Bytes: s.Current}
+		if s.Total > 0 {
+			e.Total = s.Total
+		}
+		switch event {
+		case "progress":
+			speed := s.Speed
+			e.SpeedBps = &speed
+			if eta := s.etaSeconds(); eta >= 0 {
+				e.ETASeconds = &eta
+			}
+		case "retry":
+			e.Retry, e.MaxRetries, e.Error = s.Retry, s.MaxRetries, s.RetryReason
+		case "error":
+			e.Error = s.Error
+		}
+		if err := r.enc.Encode(e); err != nil {
+			appLogger.Printf("[Progress] Failed to write JSON event: %v", err)
+		}
+		return
+	}
+
+	size := "unknown size"
+	if s.Total > 0 {
+		size = formatBytes(s.Total)
+	}
+	var detail string
+	switch event {
+	case "queued":
+		detail = fmt.Sprintf("(%s)", size)
+	case "started":
+		if s.Current > 0 {
+			detail = fmt.Sprintf("(resuming at %s of %s)", formatBytes(s.Current), size)
+		}
+	case "retry":
+		detail = fmt.Sprintf("retry %d/%d: %s", s.Retry, s.MaxRetries, s.RetryReason)
+	case "finished":
+		detail = fmt.Sprintf("(%s)", formatBytes(s.Current))
+	case "error":
+		detail = s.Error
+	}
+	fmt.Fprintln(r.out, strings.TrimSpace(fmt.Sprintf("%s %-8s %s %s", now.Format("15:04:05"), event, s.File, detail)))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	throttleChunkSize = 64 * 1024 // Largest read passed through a limiter at once, keeps waits short and smooth
+	minRateLimit      = 1024      // SIGUSR1 never lowers a cap below 1 KB/s
+)
+
+// rateLimiter is a token bucket shared by every download it applies to. A rate of
+// 0 means unlimited. The rate can be changed while downloads are running.
+type rateLimiter struct {
+	mu     sync.Mutex
+	host   string  // Host the cap applies to, "" for the global limiter
+	rate   float64 // Bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// downloadRateLimit is shared by all downloads, set by main.go via -limit-rate.
+var downloadRateLimit = &rateLimiter{}
+
+// hostRateLimits holds the per-host caps, set by main.go via -limit-host.
+var hostRateLimits []*rateLimiter
+
+func (l *rateLimiter) limit() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+func (l *rateLimiter) setLimit(bytesPerSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
+	}
+	l.rate = bytesPerSecond
+	if l.tokens > l.burstLocked() {
+		l.tokens = l.burstLocked()
+	}
+}
+
+// burstLocked allows a quarter second worth of bytes to pass without waiting.
+func (l *rateLimiter) burstLocked() float64 {
+	burst := l.rate / 4
+	if burst < throttleChunkSize {
+		burst = throttleChunkSize
+	}
+	return burst
+}
+
+// wait takes n bytes from the bucket, sleeping until they are covered. Tokens may go
+// negative, so concurrent callers queue up behind each other instead of all waking
+// at once.
+func (l *rateLimiter) wait(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if burst := l.burstLocked(); l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// matches reports whether the cap applies to host, which may be a subdomain of l.host.
+func (l *rateLimiter) matches(host string) bool {
+	host = strings.ToLower(host)
+	return host == l.host || strings.HasSuffix(host, "."+l.host)
+}
+
+// throttledReader applies the limiters to everything read through it.
+type throttledReader struct {
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		l.wait(n)
+	}
+	return n, err
+}
+
+// throttle wraps a response body in the global limiter and the caps of every host
+// the request went through (the original URL and, after redirects, the final one).
+// Limiters are applied even when unlimited so a cap set at runtime takes effect on
+// running downloads.
+func throttle(body io.Reader, fileURL string, finalURL *url.URL) io.Reader {
+	limiters := []*rateLimiter{downloadRateLimit}
+	var hosts []string
+	if u, err := url.Parse(fileURL); err == nil {
+		hosts = append(hosts, u.Hostname())
+	}
+	if finalURL != nil {
+		hosts = append(hosts, finalURL.Hostname())
+	}
+	for _, l := range hostRateLimits {
+		for _, host := range hosts {
+			if l.matches(host) {
+				limiters = append(limiters, l)
+				break
+			}
+		}
+	}
+	return &throttledReader{r: body, limiters: limiters}
+}
+
+// parseRate parses a rate such as "50M", "512K", "1.5G" or "800000" (bytes per
+// second). Suffixes are binary, like formatSpeed, and may be followed by "B" and "/s".
+// "0" means unlimited.
+func parseRate(value string) (float64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "/S")
+	s = strings.TrimSuffix(s, "B")
+	multiplier := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1024
+		case 'M':
+			multiplier = 1024 * 1024
+		case 'G':
+			multiplier = 1024 * 1024 * 1024
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate '%s' (expected e.g. 50M, 512K or 0 for unlimited)", value)
+	}
+	return n * multiplier, nil
+}
+
+// hostRateFlag collects repeated -limit-host host=rate flags into hostRateLimits.
+type hostRateFlag struct{}
+
+func (hostRateFlag) String() string { return "" }
+
+func (hostRateFlag) Set(value string) error {
+	host, rateStr, ok := strings.Cut(value, "=")
+	host = strings.ToLower(strings.TrimSpace(host))
+	if !ok || host == "" {
+		return fmt.Errorf("expected host=rate, got '%s'", value)
+	}
+	rate, err := parseRate(rateStr)
+	if err != nil {
+		return err
+	}
+	for _, l := range hostRateLimits {
+		if l.host == host {
+			l.setLimit(rate)
+			return nil
+		}
+	}
+	hostRateLimits = append(hostRateLimits, &rateLimiter{host: host, rate: rate})
+	return nil
+}
+
+// adjustRateLimits scales every active cap by factor at runtime. Without a global
+// cap, lowering starts from the current overall speed so a running pull can still
+// be throttled.
+func adjustRateLimits(factor float64) {
+	global := downloadRateLimit.limit()
+	if global <= 0 && factor < 1 && manager != nil {
+		global = manager.currentSpeed()
+	}
+	if global > 0 {
+		newRate := global * factor
+		if newRate < minRateLimit {
+			newRate = minRateLimit
+		}
+		downloadRateLimit.setLimit(newRate)
+		appLogger.Printf("[RateLimit] Global cap changed from %s to %s.", strings.TrimSpace(formatSpeed(global)), strings.TrimSpace(formatSpeed(newRate)))
+	} else {
+		appLogger.Println("[RateLimit] No global cap to adjust.")
+	}
+	for _, l := range hostRateLimits {
+		if rate := l.limit(); rate > 0 {
+			newRate := rate * factor
+			if newRate < minRateLimit {
+				newRate = minRateLimit
+			}
+			l.setLimit(newRate)
+			appLogger.Printf("[RateLimit] Cap for %s changed from %s to %s.", l.host, strings.TrimSpace(formatSpeed(rate)), strings.TrimSpace(formatSpeed(newRate)))
+		}
+	}
+	if manager != nil {
+		manager.requestRedraw()
+	}
+}
+
+// rateLimitSummary describes the active caps for the overall progress line, or
+// returns "" if nothing is capped.
+func rateLimitSummary() string {
+	var parts []string
+	if rate := downloadRateLimit.limit(); rate > 0 {
+		parts = append(parts, strings.TrimSpace(formatSpeed(rate)))
+	}
+	for _, l := range hostRateLimits {
+		if rate := l.limit(); rate > 0 {
+			parts = append(parts, l.host+" "+strings.TrimSpace(formatSpeed(rate)))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "cap " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// watchRateLimitSignals is a no-op where SIGUSR1/SIGUSR2 do not exist (e.g. Windows);
+// the caps stay at what -limit-rate and -limit-host set.
+func watchRateLimitSignals() {
+	appLogger.Println("[RateLimit] Runtime adjustment via signals is not available on this platform.")
+}
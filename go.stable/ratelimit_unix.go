@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchRateLimitSignals lets the caps be changed while downloads run:
+// SIGUSR1 halves them, SIGUSR2 doubles them.
+func watchRateLimitSignals() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigChan {
+			appLogger.Printf("[RateLimit] Signal received: %s.", sig)
+			if sig == syscall.SIGUSR1 {
+				adjustRateLimits(0.5)
+			} else {
+				adjustRateLimits(2)
+			}
+		}
+	}()
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// modelAlias is what a -m alias downloads: files of a Hugging Face repository (by
+// path or glob, or the GGUF of a quantization) or a single direct URL.
+type modelAlias struct {
+	Repo     string   `json:"repo,omitempty"`     // owner/repo, also datasets/owner/repo
+	Revision string   `json:"revision,omitempty"` // Branch, tag or commit, default main
+	Files    []string `json:"files,omitempty"`    // Paths or globs within the repository
+	Quant    string   `json:"quant,omitempty"`    // GGUF quantization to pick, as with -quant
+	URL      string   `json:"url,omitempty"`      // Direct download instead of a repository
+	SHA256   string   `json:"sha256,omitempty"`   // Expected checksum if the alias is a single file
+	Dir      string   `json:"dir,omitempty"`      // Target directory, default downloads/<alias>
+}
+
+// builtinModelAliases are the aliases that work without a registry file.
+var builtinModelAliases = map[string]modelAlias{
+	"qwen3-0.6b":    {Repo: "Qwen/Qwen3-0.6B-GGUF", Files: []string{"Qwen3-0.6B-Q8_0.gguf"}},
+	"qwen3-1.7b":    {Repo: "Qwen/Qwen3-1.7B-GGUF", Files: []string{"Qwen3-1.7B-Q8_0.gguf"}},
+	"qwen3-4b":      {Repo: "Qwen/Qwen3-4B-GGUF", Files: []string{"Qwen3-4B-Q4_K_M.gguf"}},
+	"qwen3-8b":      {Repo: "Qwen/Qwen3-8B-GGUF", Files: []string{"Qwen3-8B-Q4_K_M.gguf"}},
+	"qwen3-14b":     {Repo: "Qwen/Qwen3-14B-GGUF", Files: []string{"Qwen3-14B-Q4_K_M.gguf"}},
+	"qwen3-32b":     {Repo: "Qwen/Qwen3-32B-GGUF", Files: []string{"Qwen3-32B-Q4_K_M.gguf"}},
+	"qwen3-30b-moe": {Repo: "Qwen/Qwen3-30B-A3B-GGUF", Files: []string{"Qwen3-30B-A3B-Q4_K_M.gguf"}},
+	"gemma3-27b":    {Repo: "unsloth/gemma-3-27b-it-GGUF", Files: []string{"gemma-3-27b-it-Q4_0.gguf"}},
+}
+
+// modelRegistryPath returns the user's registry file, <user config dir>/dl/models.json
+// (e.g. ~/.config/dl/models.json on Linux).
+func modelRegistryPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine the user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "dl", "models.json"), nil
+}
+
+// loadUserModelAliases reads the registry file. A null entry hides the built-in alias
+// of that name. A missing file is an empty registry.
+func loadUserModelAliases() (map[string]*modelAlias, string, error) {
+	registryPath, err := modelRegistryPath()
+	if err != nil {
+		return nil, "", err
+	}
+	aliases := make(map[string]*modelAlias)
+	data, err := os.ReadFile(registryPath)
+	if os.IsNotExist(err) {
+		return aliases, registryPath, nil
+	} else if err != nil {
+		return nil, registryPath, err
+	}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, registryPath, fmt.Errorf("%s: %w", registryPath, err)
+	}
+	for name, alias := range aliases {
+		if alias == nil {
+			continue
+		}
+		if err := alias.validate(); err != nil {
+			return nil, registryPath, fmt.Errorf("%s: alias '%s': %w", registryPath, name, err)
+		}
+	}
+	return aliases, registryPath, nil
+}
+
+func saveUserModelAliases(registryPath string, aliases map[string]*modelAlias) error {
+	if err := os.MkdirAll(filepath.Dir(registryPath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(aliases, "", "  ") // Keys come out sorted
+	if err != nil {
+		return err
+	}
+	tmpPath := registryPath + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, registryPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// modelAliases returns the built-in aliases with the user's registry merged over them.
+func modelAliases() (map[string]modelAlias, error) {
+	userAliases, _, err := loadUserModelAliases()
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]modelAlias, len(builtinModelAliases)+len(userAliases))
+	for name, alias := range builtinModelAliases {
+		merged[name] = alias
+	}
+	for name, alias := range userAliases {
+		if alias == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = *alias
+		}
+	}
+	return merged, nil
+}
+
+// lookupModelAlias finds a -m alias.
+func lookupModelAlias(name string) (modelAlias, error) {
+	aliases, err := modelAliases()
+	if err != nil {
+		return modelAlias{}, fmt.Errorf("cannot read the model registry: %w", err)
+	}
+	alias, found := aliases[name]
+	if !found {
+		return modelAlias{}, fmt.Errorf("model alias '%s' not recognized (see 'dl model list')", name)
+	}
+	return alias, nil
+}
+
+func (a *modelAlias) validate() error {
+	switch {
+	case a.URL != "" && (a.Repo != "" || len(a.Files) > 0 || a.Quant != "" || a.Revision != ""):
+		return fmt.Errorf("an alias has either a url or a repo (with files, quant and revision), not both")
+	case a.URL == "" && a.Repo == "":
+		return fmt.Errorf("an alias needs a repo or a url")
+	case a.URL != "" && !strings.HasPrefix(a.URL, "http://") && !strings.HasPrefix(a.URL, "https://"):
+		return fmt.Errorf("url must start with http:// or https://")
+	}
+	if a.Repo != "" {
+		if _, err := parseHuggingFaceRepo(a.Repo, ""); err != nil {
+			return err
+		}
+	}
+	for _, file := range a.Files {
+		if _, err := path.Match(strings.ReplaceAll(file, "**", "*"), ""); err != nil {
+			return fmt.Errorf("file '%s': %w", file, err)
+		}
+	}
+	if a.SHA256 != "" && !isHexDigest(a.SHA256, 64) {
+		return fmt.Errorf("sha256 must be 64 hex digits")
+	}
+	return nil
+}
+
+func isHexDigest(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range strings.ToLower(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// downloadDir returns where the alias is downloaded to.
+func (a modelAlias) downloadDir(name string) string {
+	if a.Dir != "" {
+		return a.Dir
+	}
+	return filepath.Join("downloads", strings.ReplaceAll(strings.ReplaceAll(name, string(os.PathSeparator), "_"), "..", ""))
+}
+
+// describe summarizes the source of an alias for 'dl model list'.
+func (a modelAlias) describe() string {
+	if a.URL != "" {
+		return a.URL
+	}
+	source := a.Repo
+	if a.Revision != "" {
+		source += "@" + a.Revision
+	}
+	var what []string
+	if len(a.Files) > 0 {
+		what = append(what, strings.Join(a.Files, ", "))
+	}
+	if a.Quant != "" {
+		what = append(what, "quant "+a.Quant)
+	}
+	if len(what) == 0 {
+		what = append(what, "all files")
+	}
+	return source + ": " + strings.Join(what, ", ")
+}
+
+// HandleModelRegistry implements 'dl model list|add|remove'.
+func HandleModelRegistry(args []string) bool {
+	switch args[0] {
+	case "list":
+		return handleModelList(args[1:])
+	case "add":
+		return handleModelAdd(args[1:])
+	case "remove":
+		return handleModelRemove(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Error: Unknown subcommand 'model %s'.\n", args[0])
+	return false
+}
+
+func handleModelList(args []string) bool {
+	listFlags := flag.NewFlagSet("model list", flag.ContinueOnError)
+	listFlags.SetOutput(os.Stderr)
+	jsonOutput := listFlags.Bool("json", false, "Print the merged registry as JSON")
+	if err := listFlags.Parse(args); err != nil {
+		return false
+	}
+	userAliases, registryPath, err := loadUserModelAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	aliases, err := modelAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(aliases); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not encode JSON: %v\n", err)
+			return false
+		}
+		return true
+	}
+
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALIAS\tSOURCE\tNOTES")
+	for _, name := range names {
+		alias := aliases[name]
+		var notes []string
+		_, builtin := builtinModelAliases[name]
+		switch _, user := userAliases[name]; {
+		case user && builtin:
+			notes = append(notes, "user (overrides built-in)")
+		case user:
+			notes = append(notes, "user")
+		default:
+			notes = append(notes, "built-in")
+		}
+		if alias.SHA256 != "" {
+			notes = append(notes, "sha256 "+alias.SHA256[:12]+"...")
+		}
+		if alias.Dir != "" {
+			notes = append(notes, "dir "+alias.Dir)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, alias.describe(), strings.Join(notes, ", "))
+	}
+	tw.Flush()
+
+	var hidden []string
+	for name, alias := range userAliases {
+		if alias == nil {
+			hidden = append(hidden, name)
+		}
+	}
+	if len(hidden) > 0 {
+		sort.Strings(hidden)
+		fmt.Fprintf(os.Stderr, "[INFO] Removed built-in aliases: %s\n", strings.Join(hidden, ", "))
+	}
+	fmt.Fprintf(os.Stderr, "[INFO] Registry file: %s\n", registryPath)
+	return true
+}
+
+func handleModelAdd(args []string) bool {
+	addFlags := flag.NewFlagSet("model add", flag.ContinueOnError)
+	addFlags.SetOutput(os.Stderr)
+	var alias modelAlias
+	addFlags.StringVar(&alias.Repo, "repo", "", "Hugging Face repository, e.g. Qwen/Qwen3-8B-GGUF")
+	addFlags.StringVar(&alias.Revision, "revision", "", "Branch, tag or commit of the repository (default main)")
+	addFlags.Var((*globListFlag)(&alias.Files), "file", "File path or glob in the repository (repeatable)")
+	addFlags.StringVar(&alias.Quant, "quant", "", "GGUF quantization to download, e.g. Q4_K_M")
+	addFlags.StringVar(&alias.URL, "url", "", "Direct download URL instead of -repo")
+	addFlags.StringVar(&alias.SHA256, "sha256", "", "Expected sha256 of the (single) downloaded file")
+	addFlags.StringVar(&alias.Dir, "dir", "", "Download directory (default downloads/<alias>)")
+	var name string
+	for len(args) > 0 { // Flags may come before or after the alias
+		if err := addFlags.Parse(args); err != nil {
+			return false
+		}
+		args = addFlags.Args()
+		if len(args) > 0 {
+			if name != "" {
+				fmt.Fprintln(os.Stderr, "Error: 'model add' takes one alias.")
+				return false
+			}
+			name, args = args[0], args[1:]
+		}
+	}
+	if name == "" || strings.ContainsAny(name, " \t/\\") {
+		fmt.Fprintln(os.Stderr, "Error: 'model add' needs an alias name without spaces or slashes, e.g. 'dl model add my-qwen -repo Qwen/Qwen3-8B-GGUF -quant Q4_K_M'.")
+		return false
+	}
+	alias.SHA256 = strings.ToLower(alias.SHA256)
+	if err := alias.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false
+	}
+
+	userAliases, registryPath, err := loadUserModelAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	previous, existed := userAliases[name]
+	userAliases[name] = &alias
+	if err := saveUserModelAliases(registryPath, userAliases); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Could not write %s: %v\n", registryPath, err)
+		return false
+	}
+	appLogger.Printf("[Registry] Added alias %s -> %s", name, alias.describe())
+	_, builtin := builtinModelAliases[name]
+	switch {
+	case existed && previous != nil:
+		fmt.Fprintf(os.Stderr, "[INFO] Updated alias '%s': %s\n", name, alias.describe())
+	case builtin:
+		fmt.Fprintf(os.Stderr, "[INFO] Added alias '%s' (overrides the built-in one): %s\n", name, alias.describe())
+	default:
+		fmt.Fprintf(os.Stderr, "[INFO] Added alias '%s': %s\n", name, alias.describe())
+	}
+	return true
+}
+
+// handleModelRemove deletes a user alias. A built-in alias is hidden with a null
+// entry; if the user had overridden it, removing the override brings it back first.
+func handleModelRemove(args []string) bool {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Error: 'model remove' takes one alias.")
+		return false
+	}
+	name := args[0]
+	userAliases, registryPath, err := loadUserModelAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return false
+	}
+	userAlias, inUser := userAliases[name]
+	_, builtin := builtinModelAliases[name]
+	var message string
+	switch {
+	case inUser && userAlias != nil && builtin:
+		delete(userAliases, name)
+		message = fmt.Sprintf("Removed your alias '%s'; the built-in alias of that name applies again (remove it again to hide it).", name)
+	case inUser && userAlias != nil:
+		delete(userAliases, name)
+		message = fmt.Sprintf("Removed alias '%s'.", name)
+	case builtin && !inUser:
+		userAliases[name] = nil
+		message = fmt.Sprintf("Removed built-in alias '%s' (restore it with 'dl model add' or by deleting its entry in %s).", name, registryPath)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: There is no alias '%s'.\n", name)
+		return false
+	}
+	if err := saveUserModelAliases(registryPath, userAliases); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Could not write %s: %v\n", registryPath, err)
+		return false
+	}
+	appLogger.Printf("[Registry] %s", message)
+	fmt.Fprintf(os.Stderr, "[INFO] %s\n", message)
+	return true
+}
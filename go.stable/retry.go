@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how often and how patiently a failed download is re-attempted.
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt, 0 disables retrying
+	BaseDelay  time.Duration // Delay before the first retry, doubled for every further one
+	MaxDelay   time.Duration // Upper bound for the exponential backoff
+}
+
+// downloadRetryPolicy is used by downloadFile, set by main.go via -retries and -retry-delay.
+var downloadRetryPolicy = RetryPolicy{MaxRetries: 5, BaseDelay: 2 * time.Second, MaxDelay: 60 * time.Second}
+
+// maxRetryAfter caps a server-provided Retry-After so a bogus header cannot stall dl for hours.
+const maxRetryAfter = 10 * time.Minute
+
+// delay returns how long to wait before the given retry (1-based). A Retry-After
+// from the server takes precedence over the computed backoff.
+func (p RetryPolicy) delay(retry int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > maxRetryAfter {
+			return maxRetryAfter
+		}
+		return retryAfter
+	}
+	backoff := p.BaseDelay
+	for i := 1; i < retry && backoff < p.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	// Jitter in [backoff/2, backoff) so parallel downloads do not retry in lockstep.
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryableError wraps a failure that may succeed if the request is repeated.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration // From the Retry-After header, 0 if absent
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// errDownloadCanceled reports that a transfer stopped because dl is shutting down.
+// It wraps context.Canceled, so errors.Is(err, context.Canceled) matches either.
+var errDownloadCanceled = fmt.Errorf("download canceled: %w", context.Canceled)
+
+// downloadCtx is the context of every download request. cancelDownloads cancels it
+// when dl starts shutting down, which aborts requests in flight and pending retry waits.
+var downloadCtx, cancelDownloadCtx = context.WithCancel(context.Background())
+
+// downloadWorkers counts the downloadFile workers still running, so shutdown can wait
+// for them to save their resume records. workersMu keeps Add from racing with Wait.
+var (
+	downloadWorkers sync.WaitGroup
+	workersMu       sync.Mutex
+)
+
+// startDownloadWorker registers a downloadFile worker, to be released with
+// downloadWorkers.Done. It reports false once the downloads are canceled.
+func startDownloadWorker() bool {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	if downloadCtx.Err() != nil {
+		return false
+	}
+	downloadWorkers.Add(1)
+	return true
+}
+
+// cancelDownloads cancels every request in flight and pending retry wait. It may be
+// called more than once.
+func cancelDownloads() {
+	workersMu.Lock()
+	cancelDownloadCtx()
+	workersMu.Unlock()
+}
+
+// waitForDownloadWorkers waits up to timeout for the registered workers to return,
+// and reports whether they did.
+func waitForDownloadWorkers(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		downloadWorkers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// waitToRetry sleeps for delay and reports false if the downloads are canceled first.
+func waitToRetry(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-downloadCtx.Done():
+		return false
+	}
+}
+
+// diskWriteError marks a failure writing to the local file, which retrying the
+// request will not fix.
+type diskWriteError struct{ err error }
+
+func (e *diskWriteError) Error() string { return e.err.Error() }
+func (e *diskWriteError) Unwrap() error { return e.err }
+
+type diskWriter struct{ w io.Writer }
+
+func (d diskWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	if err != nil {
+		err = &diskWriteError{err: err}
+	}
+	return n, err
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter understands both forms of Retry-After: delay-seconds and an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// statusError carries the status code of an unexpected response, so callers can
+// tell e.g. a 403 from a gated repo apart from other failures via errors.As.
+type statusError struct {
+	StatusCode int
+	err        error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// hfStatusHints explains what the access statuses mean when they come from the Hub.
+var hfStatusHints = map[int]string{
+	http.StatusUnauthorized: "token missing or invalid",
+	http.StatusForbidden:    "gated repo, terms not accepted",
+	http.StatusNotFound:     "wrong repo, revision or file",
+}
+
+// httpStatusError turns an unexpected response into an error with a short body
+// snippet, marked retryable for transient statuses.
+func httpStatusError(resp *http.Response) error {
+	errorBodySnippet := ""
+	if resp.ContentLength > 0 && resp.ContentLength < 1024 {
+		bodyBytes, readErr := io.ReadAll(resp.Body)
+		if readErr == nil {
+			errorBodySnippet = strings.TrimSpace(string(bodyBytes))
+			if len(errorBodySnippet) > 100 {
+				errorBodySnippet = errorBodySnippet[:100] + "..."
+			}
+		}
+	}
+	if hint, ok := hfStatusHints[resp.StatusCode]; ok && resp.Request != nil && isHFHost(resp.Request.URL.Host) {
+		errorBodySnippet = hint
+	}
+	var err error = &statusError{StatusCode: resp.StatusCode, err: fmt.Errorf("HTTP %s", resp.Status)}
+	if errorBodySnippet != "" {
+		err = &statusError{StatusCode: resp.StatusCode, err: fmt.Errorf("HTTP %s (%s)", resp.Status, errorBodySnippet)}
+	}
+	if isRetryableStatus(resp.StatusCode) {
+		return &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return err
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// shardTensorPrefixes limits a sharded safetensors download to the shards holding
+// tensors with these name prefixes, set by main.go via -shards.
+var shardTensorPrefixes commaListFlag
+
+const (
+	// safetensorsHeaderProbeBytes is the first Range request for a shard header; most
+	// headers are far smaller.
+	safetensorsHeaderProbeBytes = 256 * 1024
+	// safetensorsMaxHeaderSize is the limit the safetensors format itself imposes.
+	safetensorsMaxHeaderSize = 100 << 20
+	// safetensorsMaxShardLines is up to how many shards get one summary line each.
+	safetensorsMaxShardLines = 16
+)
+
+// safetensorsTensor is one entry of a safetensors header.
+type safetensorsTensor struct {
+	DType       string   `json:"dtype"`
+	Shape       []int64  `json:"shape"`
+	DataOffsets [2]int64 `json:"data_offsets"`
+}
+
+// safetensorsHeader is the JSON header at the start of a .safetensors file.
+type safetensorsHeader struct {
+	Tensors    map[string]safetensorsTensor
+	HeaderSize int64 // Length of the JSON after the 8-byte length prefix
+	DataSize   int64 // End of the last tensor's data
+}
+
+// fileSize is the size a complete file with this header has.
+func (h *safetensorsHeader) fileSize() int64 { return 8 + h.HeaderSize + h.DataSize }
+
+// safetensorsIndex is a model.safetensors.index.json and what was learned about
+// the shards it references.
+type safetensorsIndex struct {
+	Path      string                 // Path of the index in the repository
+	Metadata  map[string]interface{} `json:"metadata"`
+	WeightMap map[string]string      `json:"weight_map"` // Tensor name -> shard file name
+
+	shards   []string                      // Repository paths of the referenced shards, sorted
+	missing  []string                      // Shards not in the repository listing
+	sizes    map[string]int64              // Shard path -> size from the listing
+	headers  map[string]*safetensorsHeader // Shard path -> parsed header
+	selected []string                      // Shards that are being downloaded
+}
+
+// shardPath turns a weight_map file name into a repository path; shards live next
+// to their index.
+func (idx *safetensorsIndex) shardPath(name string) string {
+	return path.Join(path.Dir(idx.Path), name)
+}
+
+// loadSafetensorsIndexes downloads and parses every *.safetensors.index.json in the
+// repository listing and checks that the shards they reference are in the listing.
+// An index that cannot be read is reported and skipped.
+func loadSafetensorsIndexes(files []HFFile, hfToken string) []*safetensorsIndex {
+	listed := make(map[string]HFFile, len(files))
+	for _, file := range files {
+		listed[file.Filename] = file
+	}
+	var indexes []*safetensorsIndex
+	for _, file := range files {
+		if !strings.HasSuffix(file.Filename, ".safetensors.index.json") {
+			continue
+		}
+		idx, err := fetchSafetensorsIndex(file.URL, hfToken)
+		if err != nil {
+			appLogger.Printf("[Safetensors] Could not read %s: %v", file.Filename, err)
+			fmt.Fprintf(os.Stderr, "[WARN] Could not read the safetensors index %s: %v\n", file.Filename, err)
+			continue
+		}
+		idx.Path = file.Filename
+		idx.sizes = make(map[string]int64)
+		seen := make(map[string]bool)
+		for _, name := range idx.WeightMap {
+			shard := idx.shardPath(name)
+			if seen[shard] {
+				continue
+			}
+			seen[shard] = true
+			idx.shards = append(idx.shards, shard)
+			if listedFile, ok := listed[shard]; ok {
+				idx.sizes[shard] = listedFile.Size
+			} else {
+				idx.missing = append(idx.missing, shard)
+			}
+		}
+		sort.Strings(idx.shards)
+		sort.Strings(idx.missing)
+		appLogger.Printf("[Safetensors] %s: %d tensors in %d shards, %d missing from the listing.", idx.Path, len(idx.WeightMap), len(idx.shards), len(idx.missing))
+		if len(idx.missing) > 0 {
+			var problems []string
+			for _, shard := range idx.missing {
+				problems = append(problems, shard+" is not in the repository")
+			}
+			printSafetensorsProblems("[ERROR]", fmt.Sprintf("%s references %d shard(s) missing from the repository:", idx.Path, len(idx.missing)), problems)
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes
+}
+
+func fetchSafetensorsIndex(indexURL string, hfToken string) (*safetensorsIndex, error) {
+	req, err := newDownloadRequest(indexURL, hfToken)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newDownloadClient("[Safetensors]").Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpStatusError(resp)
+	}
+	var idx safetensorsIndex
+	if err := json.NewDecoder(resp.Body).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if len(idx.WeightMap) == 0 {
+		return nil, fmt.Errorf("no weight_map")
+	}
+	return &idx, nil
+}
+
+// parseSafetensorsHeader reads the length-prefixed JSON header of a safetensors file.
+func parseSafetensorsHeader(r io.Reader) (*safetensorsHeader, error) {
+	var prefix [8]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("reading header length: %w", err)
+	}
+	headerSize := binary.LittleEndian.Uint64(prefix[:])
+	if headerSize < 2 || headerSize > safetensorsMaxHeaderSize {
+		return nil, fmt.Errorf("implausible header length %d, not a safetensors file", headerSize)
+	}
+	raw := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("reading %d-byte header: %w", headerSize, err)
+	}
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("invalid header JSON: %w", err)
+	}
+	header := &safetensorsHeader{Tensors: make(map[string]safetensorsTensor, len(entries)), HeaderSize: int64(headerSize)}
+	for name, entry := range entries {
+		if name == "__metadata__" {
+			continue
+		}
+		var tensor safetensorsTensor
+		if err := json.Unmarshal(entry, &tensor); err != nil {
+			return nil, fmt.Errorf("tensor %s: %w", name, err)
+		}
+		if tensor.DataOffsets[0] < 0 || tensor.DataOffsets[1] < tensor.DataOffsets[0] {
+			return nil, fmt.Errorf("tensor %s has invalid data offsets %v", name, tensor.DataOffsets)
+		}
+		if tensor.DataOffsets[1] > header.DataSize {
+			header.DataSize = tensor.DataOffsets[1]
+		}
+		header.Tensors[name] = tensor
+	}
+	return header, nil
+}
+
+// fetchSafetensorsHeader parses the header of a remote shard with Range requests.
+func fetchSafetensorsHeader(fileURL string, hfToken string) (*safetensorsHeader, error) {
+	rr := &httpRangeReader{url: fileURL, token: hfToken, chunk: safetensorsHeaderProbeBytes}
+	defer rr.Close()
+	return parseSafetensorsHeader(rr)
+}
+
+// inspectSafetensorsShards reads the header of every listed shard of every index,
+// prints the tensor count, dtypes and parameter count of the model and checks the
+// headers against the weight_map.
+func inspectSafetensorsShards(indexes []*safetensorsIndex, files []HFFile, concurrency int, hfToken string) {
+	urls := make(map[string]string, len(files))
+	for _, file := range files {
+		urls[file.Filename] = file.URL
+	}
+	for _, idx := range indexes {
+		fmt.Fprintf(os.Stderr, "[INFO] Reading safetensors headers of %d shard(s) of %s...\n", len(idx.shards)-len(idx.missing), idx.Path)
+		idx.headers = make(map[string]*safetensorsHeader)
+		headerErrs := make(map[string]error)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+		for _, shard := range idx.shards {
+			shardURL, ok := urls[shard]
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+			go func(shard, shardURL string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				header, err := fetchSafetensorsHeader(shardURL, hfToken)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					appLogger.Printf("[Safetensors] Could not read the header of %s: %v", shard, err)
+					headerErrs[shard] = err
+					return
+				}
+				idx.headers[shard] = header
+			}(shard, shardURL)
+		}
+		wg.Wait()
+
+		var problems []string
+		for _, shard := range idx.shards {
+			if err, ok := headerErrs[shard]; ok {
+				problems = append(problems, fmt.Sprintf("%s: unreadable header: %v", shard, err))
+			}
+		}
+		dtypes := make(map[string]int)
+		var tensorCount int
+		var paramCount uint64
+		var dataSize int64
+		var shardLines []string
+		for _, shard := range idx.shards {
+			header, ok := idx.headers[shard]
+			if !ok {
+				continue
+			}
+			shardDTypes := make(map[string]int)
+			var shardParams uint64
+			for name, tensor := range header.Tensors {
+				elements := uint64(1)
+				for _, dim := range tensor.Shape {
+					elements *= uint64(dim)
+				}
+				shardParams += elements
+				shardDTypes[tensor.DType]++
+				dtypes[tensor.DType]++
+				if mapped, ok := idx.WeightMap[name]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: tensor %s is not in the weight_map", shard, name))
+				} else if idx.shardPath(mapped) != shard {
+					problems = append(problems, fmt.Sprintf("%s: tensor %s is mapped to %s", shard, name, mapped))
+				}
+			}
+			tensorCount += len(header.Tensors)
+			paramCount += shardParams
+			dataSize += header.DataSize
+			if size := idx.sizes[shard]; size > 0 && size != header.fileSize() {
+				problems = append(problems, fmt.Sprintf("%s: the header describes %d bytes, the repository lists %d", shard, header.fileSize(), size))
+			}
+			shardLines = append(shardLines, fmt.Sprintf("%s: %d tensors, %s params, %s", path.Base(shard), len(header.Tensors), formatParameterCount(shardParams), formatTypeCounts(shardDTypes)))
+		}
+		for name, mapped := range idx.WeightMap {
+			shard := idx.shardPath(mapped)
+			if header, ok := idx.headers[shard]; ok {
+				if _, found := header.Tensors[name]; !found {
+					problems = append(problems, fmt.Sprintf("%s: tensor %s from the weight_map is not in the shard", shard, name))
+				}
+			}
+		}
+		if total, ok := idx.Metadata["total_size"].(float64); ok && len(headerErrs) == 0 && len(idx.missing) == 0 && int64(total) != dataSize {
+			problems = append(problems, fmt.Sprintf("the index metadata announces %d bytes of tensor data, the shards hold %d", int64(total), dataSize))
+		}
+
+		read := len(idx.headers)
+		fmt.Fprintf(os.Stderr, "[INFO] %s: %d of %d shard header(s) read, %d tensors, %s parameters (%s).\n",
+			idx.Path, read, len(idx.shards), tensorCount, formatParameterCount(paramCount), formatTypeCounts(dtypes))
+		for _, line := range shardLines {
+			appLogger.Printf("[Safetensors] %s", line)
+			if len(shardLines) <= safetensorsMaxShardLines {
+				fmt.Fprintf(os.Stderr, "[INFO]   %s\n", line)
+			}
+		}
+		if len(problems) > 0 {
+			sort.Strings(problems)
+			printSafetensorsProblems("[WARN]", fmt.Sprintf("%s does not match its shards:", idx.Path), problems)
+		}
+	}
+}
+
+// printSafetensorsProblems prints a problem list, shortened on the terminal (the log
+// gets all of it).
+func printSafetensorsProblems(level string, title string, problems []string) {
+	const maxLines = 10
+	fmt.Fprintf(os.Stderr, "%s %s\n", level, title)
+	for i, problem := range problems {
+		appLogger.Printf("[Safetensors] %s", problem)
+		if i < maxLines {
+			fmt.Fprintf(os.Stderr, "        - %s\n", problem)
+		}
+	}
+	if len(problems) > maxLines {
+		fmt.Fprintf(os.Stderr, "        ... and %d more (see log.log with -debug)\n", len(problems)-maxLines)
+	}
+}
+
+// selectShardsByPrefix keeps only the shards that hold at least one tensor whose
+// name starts with one of the prefixes; files that are no shard (config, tokenizer,
+// the index itself) are kept. Every prefix has to match some tensor.
+func selectShardsByPrefix(files []HFFile, indexes []*safetensorsIndex, prefixes []string) ([]HFFile, error) {
+	allShards := make(map[string]bool)
+	wanted := make(map[string]bool)
+	matched := make(map[string]int)
+	for _, idx := range indexes {
+		for _, shard := range idx.shards {
+			allShards[shard] = true
+		}
+		for name, mapped := range idx.WeightMap {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(name, prefix) {
+					wanted[idx.shardPath(mapped)] = true
+					matched[prefix]++
+				}
+			}
+		}
+	}
+	for _, prefix := range prefixes {
+		if matched[prefix] == 0 {
+			return nil, fmt.Errorf("-shards: no tensor name starts with '%s'", prefix)
+		}
+	}
+	var kept []HFFile
+	for _, file := range files {
+		if !allShards[file.Filename] || wanted[file.Filename] {
+			kept = append(kept, file)
+		} else {
+			appLogger.Printf("[Safetensors] -shards: skipping %s", file.Filename)
+		}
+	}
+	var counts []string
+	for _, prefix := range prefixes {
+		counts = append(counts, fmt.Sprintf("'%s' (%d tensors)", prefix, matched[prefix]))
+	}
+	fmt.Fprintf(os.Stderr, "[INFO] -shards: %d of %d shard(s) hold %s.\n", len(wanted), len(allShards), strings.Join(counts, ", "))
+	return kept, nil
+}
+
+// markSelectedShards records which shards of each index are going to be downloaded.
+func markSelectedShards(indexes []*safetensorsIndex, files []HFFile) {
+	selected := make(map[string]bool, len(files))
+	for _, file := range files {
+		selected[file.Filename] = true
+	}
+	for _, idx := range indexes {
+		idx.selected = nil
+		for _, shard := range idx.shards {
+			if selected[shard] {
+				idx.selected = append(idx.selected, shard)
+			}
+		}
+	}
+}
+
+// validateSafetensorsDownload checks that every selected shard is on disk with the
+// size its header (or else the listing) gives.
+func validateSafetensorsDownload(downloadDir string, indexes []*safetensorsIndex, pws []*ProgressWriter) {
+	failed := make(map[string]string) // Shard path -> download error
+	for _, pw := range pws {
+		if pw == nil {
+			continue
+		}
+		pw.mu.Lock()
+		if pw.ErrorMsg != "" {
+			failed[filepath.ToSlash(pw.ActualFileName)] = pw.ErrorMsg
+		}
+		pw.mu.Unlock()
+	}
+	for _, idx := range indexes {
+		if len(idx.selected) == 0 {
+			continue
+		}
+		var problems []string
+		for _, shard := range idx.selected {
+			if errMsg, ok := failed[shard]; ok {
+				problems = append(problems, fmt.Sprintf("%s failed to download (%s)", shard, errMsg))
+				continue
+			}
+			info, err := os.Stat(filepath.Join(downloadDir, filepath.FromSlash(shard)))
+			if err != nil {
+				problems = append(problems, shard+" is missing")
+				continue
+			}
+			expected := idx.sizes[shard]
+			if header, ok := idx.headers[shard]; ok {
+				expected = header.fileSize()
+			}
+			if expected > 0 && info.Size() != expected {
+				problems = append(problems, fmt.Sprintf("%s has %d bytes, expected %d", shard, info.Size(), expected))
+			}
+		}
+		scope := "all"
+		if len(idx.selected) < len(idx.shards) {
+			scope = fmt.Sprintf("%d of %d", len(idx.selected), len(idx.shards))
+		}
+		if len(problems) == 0 {
+			appLogger.Printf("[Safetensors] %s: %s shards present and complete.", idx.Path, scope)
+			fmt.Fprintf(os.Stderr, "[INFO] Safetensors %s: %s shards present and complete.\n", idx.Path, scope)
+			continue
+		}
+		printSafetensorsProblems("[ERROR]", fmt.Sprintf("Safetensors %s (%s shards) is incomplete:", idx.Path, scope), problems)
+	}
+}
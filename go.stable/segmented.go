@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minSegmentedFileSize     = 64 * 1024 * 1024 // Files smaller than this are fetched over one connection
+	minSegmentSize           = 16 * 1024 * 1024 // Never split a file into segments smaller than this
+	maxDownloadSegments      = 32
+	segmentStateSaveInterval = 2 * time.Second
+)
+
+var downloadSegments = 1 // Connections per file, set by main.go via -segments
+
+// segmentState describes the byte range owned by one connection of a segmented download.
+type segmentState struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`  // Inclusive
+	Done  int64 `json:"done"` // Bytes already written, counted from Start
+}
+
+func (s *segmentState) remaining() int64 { return s.End - s.Start + 1 - s.Done }
+
+// doneBytes sums the progress of all segments of the record.
+func (r *resumeRecord) doneBytes() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var done int64
+	for _, seg := range r.Segments {
+		done += seg.Done
+	}
+	return done
+}
+
+// splitSegments divides total bytes into at most n contiguous segments.
+func splitSegments(total int64, n int) []*segmentState {
+	if maxByMinSize := int(total / minSegmentSize); n > maxByMinSize {
+		n = maxByMinSize
+	}
+	if n < 1 {
+		n = 1
+	}
+	var segments []*segmentState
+	segSize := total / int64(n)
+	for i := 0; i < n; i++ {
+		start := int64(i) * segSize
+		end := start + segSize - 1
+		if i == n-1 {
+			end = total - 1
+		}
+		segments = append(segments, &segmentState{Start: start, End: end})
+	}
+	return segments
+}
+
+// probeRangeSupport issues a one-byte Range request and returns the response if the
+// server honors byte ranges, with the full size reported by Content-Range. A non-nil
+// error means the probe itself failed and says nothing about range support.
+func probeRangeSupport(client *http.Client, fileURL string, hfToken string) (*http.Response, int64, error) {
+	req, err := newDownloadRequest(fileURL, hfToken)
+	if err != nil {
+		return nil, -1, fmt.Errorf("Req create: %v", shortenError(err, 25))
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := client.Do(req)
+	if ctxErr := downloadCtx.Err(); err != nil && ctxErr != nil {
+		return nil, -1, ctxErr
+	}
+	if err != nil {
+		appLogger.Printf("[Segmented] Range probe for %s failed: %v", fileURL, err)
+		return nil, -1, &retryableError{err: fmt.Errorf("GET: %v", shortenError(err, 25))}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		appLogger.Printf("[Segmented] Range probe for %s returned %s, ranges not supported.", fileURL, resp.Status)
+		return nil, -1, nil
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, -1, httpStatusError(resp)
+	}
+	// Content-Range: bytes 0-0/12345
+	contentRange := resp.Header.Get("Content-Range")
+	slash := strings.LastIndex(contentRange, "/")
+	if slash < 0 {
+		return nil, -1, nil
+	}
+	total, err := strconv.ParseInt(contentRange[slash+1:], 10, 64)
+	if err != nil || total <= 0 {
+		appLogger.Printf("[Segmented] Could not parse Content-Range '%s' for %s.", contentRange, fileURL)
+		return nil, -1, nil
+	}
+	return resp, total, nil
+}
+
+// segmentWriter writes one segment's bytes at their offset in the shared file
+// and records progress in the resume record.
+type segmentWriter struct {
+	out    *os.File
+	seg    *segmentState
+	record *resumeRecord
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	w.record.mu.Lock()
+	offset := w.seg.Start + w.seg.Done
+	remaining := w.seg.remaining()
+	w.record.mu.Unlock()
+	if int64(len(p)) > remaining {
+		return 0, fmt.Errorf("segment overflow: got %d bytes, %d remaining", len(p), remaining)
+	}
+	n, err := w.out.WriteAt(p, offset)
+	w.record.mu.Lock()
+	w.seg.Done += int64(n)
+	w.record.mu.Unlock()
+	return n, err
+}
+
+func downloadSegment(client *http.Client, pw *ProgressWriter, out *os.File, record *resumeRecord, seg *segmentState, hfToken string) error {
+	record.mu.Lock()
+	start, end := seg.Start+seg.Done, seg.End
+	record.mu.Unlock()
+	if start > end {
+		return nil
+	}
+
+	req, err := newDownloadRequest(pw.URL, hfToken)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if validator := record.ifRange(); validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctxErr := downloadCtx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return &retryableError{err: fmt.Errorf("GET: %v", shortenError(err, 25))}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return errRemoteChanged // If-Range did not match, the server sent the whole new file
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return httpStatusError(resp)
+	}
+
+	sw := &segmentWriter{out: out, seg: seg, record: record}
+	body := throttle(resp.Body, pw.URL, resp.Request.URL)
+	buf := make([]byte, 256*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := sw.Write(buf[:n]); err != nil {
+				return fmt.Errorf("Write: %v", shortenError(err, 25))
+			}
+			if _, err := pw.Write(buf[:n]); err != nil {
+				return errDownloadCanceled // The download was marked finished, e.g. during shutdown
+			}
+		}
+		if readErr != nil {
+			if ctxErr := downloadCtx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			record.mu.Lock()
+			left := seg.remaining()
+			record.mu.Unlock()
+			if left == 0 {
+				return nil
+			}
+			return &retryableError{err: fmt.Errorf("Copy: %v (%d bytes left)", shortenError(readErr, 25), left)}
+		}
+	}
+}
+
+// errRemoteChanged reports that the remote file no longer matches the partial download.
+var errRemoteChanged = &retryableError{err: errors.New("Remote file changed, restarting")}
+
+// downloadFileSegmented makes one attempt at downloading pw.URL into partPath over
+// several Range requests. If record has no segments a new segmented download is
+// started. handled is false when the server does not support byte ranges, in which
+// case the caller should fall back to a single-stream download from scratch.
+// Segment progress is kept in the record on failure, so a repeated call resumes
+// every segment.
+func downloadFileSegmented(pw *ProgressWriter, partPath string, record *resumeRecord, hfToken string, logPrefix string) (handled bool, err error) {
+	client := newDownloadClient(logPrefix)
+	record.mu.Lock()
+	resuming, recordedSize := len(record.Segments) > 0, record.Size
+	record.mu.Unlock()
+
+	probeResp, total, probeErr := probeRangeSupport(client, pw.URL, hfToken)
+	if probeErr != nil {
+		return true, probeErr
+	}
+	if probeResp == nil {
+		if resuming {
+			appLogger.Printf("%s Server no longer accepts ranges, discarding partial download.", logPrefix)
+			discardPartFile(partPath, record)
+			record.reset(-1)
+		}
+		return false, nil
+	}
+	if resuming && (recordedSize != total || !record.sameObject(probeResp)) {
+		appLogger.Printf("%s Remote file changed (size %d -> %d or validator differs). Restarting.", logPrefix, recordedSize, total)
+		resuming = false
+	}
+
+	var out *os.File
+	if !resuming {
+		segments := splitSegments(total, downloadSegments)
+		if len(segments) < 2 {
+			record.mu.Lock()
+			hadSegments := len(record.Segments) > 0
+			record.mu.Unlock()
+			if hadSegments {
+				// The preallocated part file and its segments belong to the old object.
+				appLogger.Printf("%s Remote file too small to split now, discarding partial download.", logPrefix)
+				discardPartFile(partPath, record)
+				record.reset(-1)
+			}
+			return false, nil
+		}
+		record.reset(total)
+		record.setValidators(probeResp)
+		record.mu.Lock()
+		record.Segments = segments
+		record.mu.Unlock()
+		out, err = os.Create(partPath)
+		if err == nil {
+			err = out.Truncate(total) // Preallocate so every segment can write at its offset
+		}
+	} else {
+		out, err = os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0644)
+	}
+	if err != nil {
+		return true, fmt.Errorf("Open file '%s': %v", partPath, shortenError(err, 20))
+	}
+	defer out.Close()
+
+	if err := record.save(); err != nil {
+		return true, fmt.Errorf("Save resume record: %v", shortenError(err, 25))
+	}
+
+	pw.mu.Lock()
+	pw.Total = total
+	pw.Current = record.doneBytes()
+	pw.mu.Unlock()
+	if pw.manager != nil {
+		pw.manager.requestRedraw()
+	}
+	appLogger.Printf("%s Segmented download of %d bytes over %d segment(s), %d bytes already present.", logPrefix, total, len(record.Segments), pw.Current)
+
+	stopSaver := make(chan struct{})
+	saverDone := make(chan struct{})
+	go func() {
+		defer close(saverDone)
+		ticker := time.NewTicker(segmentStateSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopSaver:
+				return
+			case <-ticker.C:
+				if err := record.save(); err != nil {
+					appLogger.Printf("%s Failed to save resume record: %v", logPrefix, err)
+				}
+			}
+		}
+	}()
+
+	var segWG sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	for i, seg := range record.Segments {
+		if seg.remaining() <= 0 {
+			continue
+		}
+		segWG.Add(1)
+		go func(idx int, s *segmentState) {
+			defer segWG.Done()
+			if err := downloadSegment(client, pw, out, record, s, hfToken); err != nil {
+				appLogger.Printf("%s Segment %d failed: %v", logPrefix, idx, err)
+				errMu.Lock()
+				// A permanent failure in any segment outranks a retryable one.
+				var retryErr *retryableError
+				if firstErr == nil || (errors.As(firstErr, &retryErr) && !errors.As(err, &retryErr)) {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(i, seg)
+	}
+	segWG.Wait()
+	close(stopSaver)
+	<-saverDone
+
+	if firstErr == errRemoteChanged {
+		record.mu.Lock()
+		record.Segments = nil // Start over on the next attempt
+		record.mu.Unlock()
+	}
+	if firstErr != nil {
+		if err := record.save(); err != nil {
+			appLogger.Printf("%s Failed to save resume record after error: %v", logPrefix, err)
+		}
+		return true, firstErr
+	}
+
+	if err := out.Sync(); err != nil {
+		return true, fmt.Errorf("Sync: %v", shortenError(err, 25))
+	}
+	// Segments arrive out of order, so the checksum can only be computed once the file is complete.
+	if pw.ExpectedSHA256 != "" {
+		actual, err := hashFileSHA256(partPath, -1)
+		if err != nil {
+			return true, fmt.Errorf("Hash: %v", shortenError(err, 25))
+		}
+		if msg := sha256MismatchMsg(pw.ExpectedSHA256, actual); msg != "" {
+			appLogger.Printf("%s Checksum mismatch for '%s': expected %s, got %s. Removing file.", logPrefix, partPath, pw.ExpectedSHA256, actual)
+			out.Close()
+			discardPartFile(partPath, record)
+			record.reset(-1)
+			return true, errors.New(msg)
+		}
+		pw.mu.Lock()
+		pw.SHA256 = actual
+		pw.mu.Unlock()
+	}
+	appLogger.Printf("%s Segmented download complete for '%s'.", logPrefix, partPath)
+	return true, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// buildGGUFSelection groups the GGUF files of a repository into split series and
+// standalone files, fetching the sizes that the tree API did not provide. The items
+// are sorted by display name, which is also the order of the interactive menu.
+func buildGGUFSelection(files []HFFile, hfFileSizes map[string]int64, concurrency int, hfToken string) []SelectableGGUFItem {
+	ggufSeriesMap := make(map[string]*GGUFSeriesInfo)
+	standaloneGGUFs := []HFFile{}
+	var filesToGetSize []HFFile
+
+	for _, hfFile := range files {
+		if strings.HasSuffix(strings.ToLower(hfFile.Filename), ".gguf") {
+			if _, known := hfFileSizes[hfFile.URL]; !known {
+				filesToGetSize = append(filesToGetSize, hfFile)
+			}
+			matches := ggufSeriesRegex.FindStringSubmatch(hfFile.Filename)
+			if len(matches) == 4 {
+				baseName := matches[1]
+				partNumStr := matches[2]
+				totalPartsStr := matches[3]
+				partNum, _ := strconv.Atoi(partNumStr)
+				totalPartsInName, _ := strconv.Atoi(totalPartsStr)
+				seriesKey := fmt.Sprintf("%s-of-%s", baseName, totalPartsStr)
+				if _, ok := ggufSeriesMap[seriesKey]; !ok {
+					ggufSeriesMap[seriesKey] = &GGUFSeriesInfo{BaseName: baseName, TotalParts: totalPartsInName, SeriesKey: seriesKey}
+				}
+				seriesInfo := ggufSeriesMap[seriesKey]
+				seriesInfo.FilesWithPart = append(seriesInfo.FilesWithPart, GGUFFileWithPartNum{File: hfFile, PartNum: partNum})
+			} else {
+				standaloneGGUFs = append(standaloneGGUFs, hfFile)
+			}
+		}
+	}
+	appLogger.Printf("[Main] Found %d GGUF series groups and %d standalone GGUF files.", len(ggufSeriesMap), len(standaloneGGUFs))
+
+	if len(filesToGetSize) > 0 {
+		fmt.Fprintf(os.Stderr, "[INFO] Fetching sizes for %d GGUF file(s) (this may take a moment)...\n", len(filesToGetSize))
+		var sizeWG sync.WaitGroup
+		sizeSem := make(chan struct{}, concurrency) // Use concurrency for HEAD/GET requests
+		processedCount := 0
+		totalToProcess := len(filesToGetSize)
+		var mu sync.Mutex
+
+		for _, hfFileToSize := range filesToGetSize {
+			sizeWG.Add(1)
+			go func(file HFFile) {
+				defer sizeWG.Done()
+				sizeSem <- struct{}{}
+				defer func() { <-sizeSem }()
+				size, errSize := fetchSingleFileSize(file.URL, hfToken) // Changed variable name
+				mu.Lock()
+				if errSize != nil {
+					appLogger.Printf("[SelectSizeFetch] Error getting size for %s: %v", file.Filename, errSize)
+					hfFileSizes[file.URL] = -1
+				} else {
+					hfFileSizes[file.URL] = size
+				}
+				processedCount++
+				fmt.Fprintf(os.Stderr, "\rFetching GGUF sizes: %d/%d complete...", processedCount, totalToProcess)
+				mu.Unlock()
+			}(hfFileToSize)
+		}
+		sizeWG.Wait()
+		fmt.Fprintln(os.Stderr, "\rFetching GGUF sizes: All complete.               ")
+	}
+
+	selectableDisplayItems := []SelectableGGUFItem{}
+	for _, seriesInfo := range ggufSeriesMap {
+		seriesInfo.TotalSize = 0
+		seriesInfo.ActualTotalPartsFound = 0
+		validParts := []GGUFFileWithPartNum{}
+		for _, partFile := range seriesInfo.FilesWithPart {
+			partSize, ok := hfFileSizes[partFile.File.URL]
+			if ok && partSize > -1 {
+				seriesInfo.TotalSize += partSize
+				partFile.Size = partSize
+				seriesInfo.ActualTotalPartsFound++
+				validParts = append(validParts, partFile)
+			} else {
+				appLogger.Printf("[SelectBuild] Part %s of series %s has unknown size or fetch error, excluding from total.", partFile.File.Filename, seriesInfo.BaseName)
+			}
+		}
+		seriesInfo.FilesWithPart = validParts
+		sort.Slice(seriesInfo.FilesWithPart, func(i, j int) bool { return seriesInfo.FilesWithPart[i].PartNum < seriesInfo.FilesWithPart[j].PartNum })
+		filesForThisSeries := []HFFile{}
+		for _, p := range seriesInfo.FilesWithPart {
+			filesForThisSeries = append(filesForThisSeries, p.File)
+		}
+		isComplete := seriesInfo.ActualTotalPartsFound == seriesInfo.TotalParts && seriesInfo.TotalParts > 0
+		completenessMark := ""
+		if seriesInfo.TotalParts > 0 && !isComplete {
+			completenessMark = fmt.Sprintf(" (INCOMPLETE: %d/%d parts found)", seriesInfo.ActualTotalPartsFound, seriesInfo.TotalParts)
+		} else if seriesInfo.TotalParts == 0 && seriesInfo.ActualTotalPartsFound > 0 {
+			completenessMark = " (WARNING: total parts in name is 0)"
+		}
+		displayName := fmt.Sprintf("Series: %s (%d parts, %s)%s", seriesInfo.BaseName, seriesInfo.ActualTotalPartsFound, formatBytes(seriesInfo.TotalSize), completenessMark)
+		quantTag, quantNames := ggufQuantNames(seriesInfo.BaseName)
+		selectableDisplayItems = append(selectableDisplayItems, SelectableGGUFItem{DisplayName: displayName, FilesToDownload: filesForThisSeries, IsSeries: true, IsComplete: isComplete || (seriesInfo.TotalParts == 0 && seriesInfo.ActualTotalPartsFound > 0), QuantTag: quantTag, TotalSize: seriesInfo.TotalSize, quantNames: quantNames})
+	}
+	for _, standaloneFile := range standaloneGGUFs {
+		size, ok := hfFileSizes[standaloneFile.URL]
+		if !ok || size == -1 {
+			size = 0
+			appLogger.Printf("[SelectBuild] Standalone GGUF %s has unknown size.", standaloneFile.Filename)
+		}
+		displayName := fmt.Sprintf("File: %s (%s)", standaloneFile.Filename, formatBytes(size))
+		quantTag, quantNames := ggufQuantNames(strings.TrimSuffix(standaloneFile.Filename, path.Ext(standaloneFile.Filename)))
+		selectableDisplayItems = append(selectableDisplayItems, SelectableGGUFItem{DisplayName: displayName, FilesToDownload: []HFFile{standaloneFile}, IsSeries: false, IsComplete: true, QuantTag: quantTag, TotalSize: size, quantNames: quantNames})
+	}
+	sort.Slice(selectableDisplayItems, func(i, j int) bool {
+		return selectableDisplayItems[i].DisplayName < selectableDisplayItems[j].DisplayName
+	})
+	return selectableDisplayItems
+}
+
+// printSelectableGGUFItems prints items numbered as in the interactive menu.
+func printSelectableGGUFItems(items []SelectableGGUFItem) {
+	for i, item := range items {
+		printSelectableGGUFItem(i, item)
+	}
+}
+
+func printSelectableGGUFItem(idx int, item SelectableGGUFItem) {
+	fmt.Fprintf(os.Stderr, "%3d. %s\n", idx+1, item.DisplayName)
+	if item.Metadata != nil {
+		if summary := item.Metadata.Summary(); summary != "" {
+			fmt.Fprintf(os.Stderr, "     %s\n", summary)
+		}
+	}
+	if item.FitNote != "" {
+		fmt.Fprintf(os.Stderr, "     %s\n", item.FitNote)
+	}
+}
+
+// ggufQuantTagRegex recognizes a quantization type among the '-'/'.'/'/' separated
+// parts of a GGUF name, e.g. Q4_K_M, IQ2_XXS, Q8_0, BF16 or MXFP4_MOE.
+var ggufQuantTagRegex = regexp.MustCompile(`(?i)^(I?Q\d+(_[A-Z0-9]+)*|TQ\d_\d|BF16|F16|F32|FP16|FP32|FP8|MXFP4(_MOE)?)$`)
+
+// ggufQuantNames returns the quantization tag of a GGUF base name (without .gguf or
+// the split suffix) and the names a -quant pattern is matched against: the tag alone
+// and the tag with the parts before it, so "UD-Q4_K_XL" selects model-UD-Q4_K_XL.
+func ggufQuantNames(baseName string) (string, []string) {
+	tokens := strings.FieldsFunc(baseName, func(r rune) bool { return r == '-' || r == '.' || r == '/' })
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if !ggufQuantTagRegex.MatchString(tokens[i]) {
+			continue
+		}
+		var names []string
+		for start := i; start >= 0; start-- {
+			names = append(names, strings.ToUpper(strings.Join(tokens[start:i+1], "-")))
+		}
+		return strings.ToUpper(tokens[i]), names
+	}
+	return "", nil
+}
+
+// selectGGUFByQuant resolves each -quant pattern (a glob such as Q4_K_M or Q5_*,
+// matched case-insensitively) to exactly one complete series or standalone file.
+// "auto" picks by memory fit, which annotateMemoryFit must have set.
+func selectGGUFByQuant(items []SelectableGGUFItem, patterns []string) ([]HFFile, error) {
+	var selected []HFFile
+	chosen := make(map[int]bool)
+	for _, pattern := range patterns {
+		if strings.EqualFold(pattern, "auto") {
+			idx, err := selectGGUFAuto(items)
+			if err != nil {
+				return nil, err
+			}
+			if !chosen[idx] {
+				chosen[idx] = true
+				fmt.Fprintln(os.Stderr, "[INFO] -quant auto selected the largest quantization that fits:")
+				printSelectableGGUFItem(idx, items[idx])
+				selected = append(selected, items[idx].FilesToDownload...)
+			}
+			continue
+		}
+		upperPattern := strings.ToUpper(pattern)
+		var matched, incomplete []int
+		for i, item := range items {
+			for _, name := range item.quantNames {
+				if ok, _ := path.Match(upperPattern, name); ok {
+					if item.IsSeries && !item.IsComplete {
+						incomplete = append(incomplete, i)
+					} else {
+						matched = append(matched, i)
+					}
+					break
+				}
+			}
+		}
+		switch {
+		case len(matched) == 1:
+			if !chosen[matched[0]] {
+				chosen[matched[0]] = true
+				fmt.Fprintf(os.Stderr, "[INFO] -quant %s selected:\n", pattern)
+				printSelectableGGUFItem(matched[0], items[matched[0]])
+				selected = append(selected, items[matched[0]].FilesToDownload...)
+			}
+		case len(matched) > 1:
+			fmt.Fprintf(os.Stderr, "[ERROR] -quant %s matches %d files/series:\n", pattern, len(matched))
+			for _, idx := range matched {
+				printSelectableGGUFItem(idx, items[idx])
+			}
+			return nil, fmt.Errorf("-quant %s is ambiguous, use a more specific tag", pattern)
+		case len(incomplete) > 0:
+			fmt.Fprintf(os.Stderr, "[ERROR] -quant %s only matches incomplete series:\n", pattern)
+			for _, idx := range incomplete {
+				printSelectableGGUFItem(idx, items[idx])
+			}
+			return nil, fmt.Errorf("no complete file or series for -quant %s", pattern)
+		default:
+			var tags []string
+			seen := make(map[string]bool)
+			for _, item := range items {
+				if item.QuantTag != "" && !seen[item.QuantTag] {
+					seen[item.QuantTag] = true
+					tags = append(tags, item.QuantTag)
+				}
+			}
+			sort.Strings(tags)
+			return nil, fmt.Errorf("no GGUF file or series matches -quant %s (available: %s)", pattern, strings.Join(tags, ", "))
+		}
+	}
+	return selected, nil
+}
+
+// stdinReader is shared by the interactive prompts, so input piped in for several of
+// them in a row is not swallowed by the buffer of the first.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// promptGGUFSelection shows the menu and asks which items to download.
+func promptGGUFSelection(items []SelectableGGUFItem) []HFFile {
+	selectedHfFiles := []HFFile{}
+	fmt.Fprintln(os.Stderr, "\nAvailable GGUF files/series for download:")
+	printSelectableGGUFItems(items)
+	fmt.Fprintln(os.Stderr, "---")
+	for {
+		fmt.Fprint(os.Stderr, "Enter numbers (e.g., 1,3), 'all' (listed GGUFs), or 'none': ")
+		userInput, _ := stdinReader.ReadString('\n')
+		userInput = strings.TrimSpace(strings.ToLower(userInput))
+		if userInput == "all" {
+			for _, item := range items {
+				if item.IsSeries && !item.IsComplete {
+					fmt.Fprintf(os.Stderr, "[WARN] Skipping incomplete series: %s\n", item.DisplayName)
+					appLogger.Printf("[MainSelect] User chose 'all', skipping incomplete series: %s", item.DisplayName)
+					continue
+				}
+				selectedHfFiles = append(selectedHfFiles, item.FilesToDownload...)
+			}
+			appLogger.Printf("[MainSelect] User chose 'all', selected %d files.", len(selectedHfFiles))
+			break
+		}
+		if userInput == "none" {
+			fmt.Fprintln(os.Stderr, "[INFO] No files selected for download.")
+			appLogger.Println("[MainSelect] User chose 'none'.")
+			break
+		}
+		parts := strings.Split(userInput, ",")
+		tempSelectedFiles := []HFFile{}
+		validSelection := true
+		if len(parts) == 0 && userInput != "" {
+			validSelection = false
+		}
+		for _, p := range parts {
+			trimmedPart := strings.TrimSpace(p)
+			if trimmedPart == "" {
+				continue
+			}
+			idx, errConv := strconv.Atoi(trimmedPart)
+			if errConv != nil || idx < 1 || idx > len(items) {
+				fmt.Fprintf(os.Stderr, "[ERROR] Invalid input: '%s'. Please enter numbers from 1 to %d, 'all', or 'none'.\n", p, len(items))
+				validSelection = false
+				break
+			}
+			selectedItem := items[idx-1]
+			if selectedItem.IsSeries && !selectedItem.IsComplete {
+				fmt.Fprintf(os.Stderr, "[WARN] Selected series '%s' is incomplete. Skipping this item.\n", selectedItem.DisplayName)
+				appLogger.Printf("[MainSelect] User selected item %d ('%s') which is an incomplete series. Skipping.", idx, selectedItem.DisplayName)
+				continue
+			}
+			tempSelectedFiles = append(tempSelectedFiles, selectedItem.FilesToDownload...)
+		}
+		if validSelection {
+			selectedHfFiles = tempSelectedFiles
+			appLogger.Printf("[MainSelect] User selected items, resulting in %d files for download.", len(selectedHfFiles))
+			break
+		}
+	}
+	return selectedHfFiles
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Actions of a sync plan.
+const (
+	syncAdd    = "add"
+	syncUpdate = "update"
+	syncDelete = "delete"
+)
+
+// syncAction is one line of the plan 'dl sync' prints before it changes anything.
+type syncAction struct {
+	Action string
+	Path   string // Slash-separated, relative to the download directory
+	Size   int64  // Remote size for add/update, size on disk for delete
+	Reason string // Why an update is needed
+	File   HFFile // Remote file, for add/update
+}
+
+// syncOptions holds the flags of 'dl sync'.
+type syncOptions struct {
+	Repo        string
+	RepoType    string
+	Revision    string
+	Dir         string
+	DryRun      bool
+	Prune       bool
+	All         bool
+	Filter      fileFilter
+	Concurrency int
+}
+
+func parseSyncArgs(args []string) (syncOptions, error) {
+	var opts syncOptions
+	syncFlags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	syncFlags.SetOutput(os.Stderr)
+	syncFlags.StringVar(&opts.Revision, "revision", defaultHFRevision, "Branch, tag or commit to sync to")
+	syncFlags.StringVar(&opts.RepoType, "repo-type", "", "Repository type: model (default), dataset or space")
+	syncFlags.StringVar(&opts.Dir, "dir", "", "Download directory (default: the one 'dl list' shows for the repo, else downloads/<owner>_<repo>)")
+	syncFlags.BoolVar(&opts.DryRun, "dry-run", false, "Only print the plan")
+	syncFlags.BoolVar(&opts.Prune, "prune", false, "Delete downloaded files that are no longer in the repository")
+	syncFlags.BoolVar(&opts.All, "all", false, "Also add new files the original download did not select (-include/-exclude, -quant, -select, -shards)")
+	syncFlags.Var((*globListFlag)(&opts.Filter.Include), "include", "Only sync files whose path matches this glob (repeatable)")
+	syncFlags.Var((*globListFlag)(&opts.Filter.Exclude), "exclude", "Do not sync files whose path matches this glob (repeatable)")
+	syncFlags.IntVar(&opts.Concurrency, "c", 3, "Number of concurrent downloads")
+	for len(args) > 0 { // Flags may come before or after the repository
+		if err := syncFlags.Parse(args); err != nil {
+			return opts, err
+		}
+		args = syncFlags.Args()
+		if len(args) > 0 {
+			if opts.Repo != "" {
+				return opts, fmt.Errorf("'sync' takes one repository")
+			}
+			opts.Repo, args = args[0], args[1:]
+		}
+	}
+	if opts.Repo == "" {
+		return opts, fmt.Errorf("missing <owner/repo> for 'sync'")
+	}
+	if opts.RepoType != "" && !isValidHFRepoType(opts.RepoType) {
+		return opts, fmt.Errorf("invalid -repo-type '%s'. Expected model, dataset or space", opts.RepoType)
+	}
+	return opts, nil
+}
+
+// HandleSync handles 'dl sync <owner/repo>': it compares the repository's files at
+// the revision with the manifest of the local download and fetches only the new and
+// changed ones, optionally deleting files removed upstream.
+func HandleSync(args []string, hfToken string) bool {
+	opts, err := parseSyncArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false
+	}
+	repo, err := parseHuggingFaceRepo(opts.Repo, opts.RepoType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false
+	}
+	downloadDir, err := syncDownloadDir(repo, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false
+	}
+	manifest, err := loadManifest(downloadDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not read the manifest in '%s': %v\n", downloadDir, err)
+		return false
+	}
+	if manifest.Repo != "" && !strings.EqualFold(manifest.Repo, repo.String()) {
+		fmt.Fprintf(os.Stderr, "Error: '%s' holds a download of %s, not %s.\n", downloadDir, manifest.Repo, repo)
+		return false
+	}
+
+	if accessErr := checkHFRepoAccess(repo, hfToken); accessErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", accessErr)
+		return false
+	}
+	files, commitSHA, err := fetchHuggingFaceURLs(repo, opts.Revision, hfToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: fetching from HF '%s': %v\n", repo, err)
+		return false
+	}
+	if manifest.Commit == "" {
+		fmt.Fprintf(os.Stderr, "[INFO] Syncing %s at commit %s into '%s' (no previous download recorded).\n", repo, commitSHA, downloadDir)
+	} else if manifest.Commit == commitSHA {
+		fmt.Fprintf(os.Stderr, "[INFO] '%s' was downloaded at the current commit %s; checking the files.\n", downloadDir, commitSHA)
+	} else {
+		fmt.Fprintf(os.Stderr, "[INFO] Syncing '%s' from commit %s to %s.\n", downloadDir, manifest.Commit, commitSHA)
+	}
+
+	actions, unchanged := planSync(downloadDir, manifest, files, opts)
+	kept := printSyncPlan(actions, unchanged, opts.Prune)
+	if opts.DryRun {
+		fmt.Fprintln(os.Stderr, "[INFO] --dry-run: nothing changed.")
+		return true
+	}
+
+	plan := &downloadPlan{Dir: downloadDir, FileSizes: make(map[string]int64), RepoID: repo.String(), CommitSHA: commitSHA, Revision: opts.Revision}
+	if len(manifest.Files) == 0 && manifest.Selection == nil {
+		plan.Selection = &DownloadSelection{Include: opts.Filter.Include, Exclude: opts.Filter.Exclude} // A first sync selects like -hf
+	}
+	remote := make(map[string]HFFile, len(files))
+	for _, file := range files {
+		remote[file.Filename] = file
+	}
+	updated := make(map[string]bool)
+	for _, action := range actions {
+		filePath := filepath.Join(downloadDir, filepath.FromSlash(action.Path))
+		switch action.Action {
+		case syncDelete:
+			if !opts.Prune {
+				continue
+			}
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "[ERROR] Could not delete '%s': %v\n", filePath, err)
+				return false
+			}
+			os.Remove(partFilePath(filePath))
+			os.Remove(resumeRecordPath(partFilePath(filePath)))
+			delete(manifest.Files, action.Path)
+			appLogger.Printf("[Sync] Deleted %s, no longer in %s.", filePath, repo)
+		case syncAdd, syncUpdate:
+			// An outdated file is kept until its replacement is downloaded and verified.
+			plan.Items = append(plan.Items, DownloadItem{URL: action.File.URL, PreferredFilename: action.File.Filename, ExpectedSHA256: action.File.SHA256, BlobID: action.File.BlobID, Replace: action.Action == syncUpdate})
+			if action.File.Size > 0 {
+				plan.FileSizes[action.File.URL] = action.File.Size
+			}
+			if action.Action == syncUpdate {
+				updated[action.Path] = true
+			}
+		}
+	}
+	// Unchanged files now belong to the new commit as well.
+	for name, entry := range manifest.Files {
+		if file, ok := remote[name]; ok && !updated[name] {
+			entry.URL = file.URL
+			if file.BlobID != "" {
+				entry.BlobID = file.BlobID
+			}
+			manifest.Files[name] = entry
+		}
+	}
+	if len(manifest.Files) > 0 || len(plan.Items) > 0 {
+		if err := os.MkdirAll(downloadDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating directory '%s': %v\n", downloadDir, err)
+			return false
+		}
+		if err := manifest.save(downloadDir); err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Could not update the manifest in '%s': %v\n", downloadDir, err)
+		}
+		if err := recordSource(downloadDir, plan); err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Could not record commit %s in '%s': %v\n", commitSHA, downloadDir, err)
+		}
+	}
+	if len(plan.Items) == 0 {
+		if kept > 0 {
+			fmt.Fprintf(os.Stderr, "[INFO] Nothing to download; %d file(s) no longer in the repository were kept (use --prune to delete them).\n", kept)
+		} else {
+			fmt.Fprintf(os.Stderr, "[INFO] '%s' is up to date with %s.\n", downloadDir, commitSHA)
+		}
+		return true
+	}
+	return runDownloads(plan, hfConcurrency(opts.Concurrency, false), hfToken) == 0
+}
+
+// syncDownloadDir picks the directory to sync: -dir, else the local download of the
+// repository if there is exactly one, else the default download directory.
+func syncDownloadDir(repo hfRepo, opts syncOptions) (string, error) {
+	if opts.Dir != "" {
+		return opts.Dir, nil
+	}
+	var dirs []string
+	for _, model := range findLocalModels(localModelRoots()) {
+		if model.Repo != "" && strings.EqualFold(model.Repo, repo.String()) {
+			dirs = append(dirs, model.Dir)
+		}
+	}
+	switch len(dirs) {
+	case 0:
+		return hfDownloadDir(repo), nil
+	case 1:
+		return dirs[0], nil
+	}
+	return "", fmt.Errorf("%s has been downloaded to %s; choose one with -dir", repo, strings.Join(dirs, ", "))
+}
+
+// planSync compares the repository listing with the manifest and the files on disk.
+// New files are only added if the selection of the original download would pick
+// them (see syncAdmitsNewFile), unless --all.
+func planSync(downloadDir string, manifest *DownloadManifest, files []HFFile, opts syncOptions) ([]syncAction, int) {
+	var actions []syncAction
+	unchanged := 0
+	listed := make(map[string]bool, len(files))
+	skippedNew, unverifiable := 0, 0
+	hashNoteShown := false
+	for _, file := range files {
+		listed[file.Filename] = true
+		if !opts.Filter.matches(file.Filename) {
+			continue
+		}
+		filePath := filepath.Join(downloadDir, filepath.FromSlash(file.Filename))
+		entry, tracked := manifest.Files[file.Filename]
+		info, statErr := os.Stat(filePath)
+		if !tracked || statErr != nil {
+			if !tracked && !opts.All && !syncAdmitsNewFile(manifest, file.Filename) {
+				skippedNew++
+				continue
+			}
+			actions = append(actions, syncAction{Action: syncAdd, Path: file.Filename, Size: file.Size, File: file})
+			continue
+		}
+
+		reason := ""
+		switch {
+		case file.Size > 0 && info.Size() != file.Size:
+			reason = fmt.Sprintf("size %s -> %s", formatBytes(info.Size()), formatBytes(file.Size))
+		case file.SHA256 != "" && entry.SHA256 != "":
+			if !strings.EqualFold(file.SHA256, entry.SHA256) {
+				reason = "sha256 changed"
+			}
+		case file.BlobID != "" && entry.BlobID != "":
+			if file.BlobID != entry.BlobID {
+				reason = "content changed"
+			}
+		case file.SHA256 != "":
+			// Recorded by an older version without a checksum: hash the file on disk.
+			if !hashNoteShown {
+				fmt.Fprintln(os.Stderr, "[INFO] Hashing local files the manifest has no checksum for...")
+				hashNoteShown = true
+			}
+			if sum, err := hashFileSHA256(filePath, -1); err != nil || !strings.EqualFold(sum, file.SHA256) {
+				reason = "sha256 differs"
+			}
+		case file.BlobID != "":
+			if blobID, err := gitBlobID(filePath); err != nil || blobID != file.BlobID {
+				reason = "content differs"
+			}
+		default:
+			unverifiable++ // The basic listing has neither sizes nor ids
+		}
+		if reason == "" {
+			unchanged++
+			continue
+		}
+		actions = append(actions, syncAction{Action: syncUpdate, Path: file.Filename, Size: file.Size, Reason: reason, File: file})
+	}
+
+	for name := range manifest.Files {
+		if listed[name] {
+			continue
+		}
+		filePath := filepath.Join(downloadDir, filepath.FromSlash(name))
+		if info, err := os.Stat(filePath); err == nil {
+			actions = append(actions, syncAction{Action: syncDelete, Path: name, Size: info.Size()})
+		} else {
+			delete(manifest.Files, name) // Gone both upstream and locally
+		}
+	}
+	if skippedNew > 0 {
+		fmt.Fprintf(os.Stderr, "[INFO] Skipping %d new file(s) outside the selection of the original download (use --all to add them).\n", skippedNew)
+	}
+	if unverifiable > 0 {
+		fmt.Fprintf(os.Stderr, "[WARN] %d file(s) could not be compared: the listing has no sizes or checksums. They are kept as they are.\n", unverifiable)
+	}
+	order := map[string]int{syncAdd: 0, syncUpdate: 1, syncDelete: 2}
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Action != actions[j].Action {
+			return order[actions[i].Action] < order[actions[j].Action]
+		}
+		return actions[i].Path < actions[j].Path
+	})
+	return actions, unchanged
+}
+
+// syncAdmitsNewFile reports whether a file that is new upstream belongs to the
+// download: a first sync takes everything, later ones follow the selection recorded
+// by -hf. Downloads recorded without one, by older versions, get no new files.
+func syncAdmitsNewFile(manifest *DownloadManifest, name string) bool {
+	if len(manifest.Files) == 0 {
+		return true
+	}
+	if manifest.Selection == nil {
+		return false
+	}
+	return manifest.Selection.admits(name)
+}
+
+// printSyncPlan prints the actions and a summary, and returns the number of files
+// that would be deleted but are kept without --prune.
+func printSyncPlan(actions []syncAction, unchanged int, prune bool) int {
+	counts := make(map[string]int)
+	sizes := make(map[string]int64)
+	marks := map[string]string{syncAdd: "+", syncUpdate: "~", syncDelete: "-"}
+	for _, action := range actions {
+		counts[action.Action]++
+		sizes[action.Action] += action.Size
+		line := fmt.Sprintf("  %s %-6s %s (%s)", marks[action.Action], action.Action, action.Path, formatBytes(action.Size))
+		if action.Reason != "" {
+			line += ", " + action.Reason
+		}
+		if action.Action == syncDelete && !prune {
+			line += ", kept without --prune"
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+	fmt.Fprintf(os.Stderr, "Plan: %d to add (%s), %d to update (%s), %d to delete (%s), %d unchanged.\n",
+		counts[syncAdd], formatBytes(sizes[syncAdd]), counts[syncUpdate], formatBytes(sizes[syncUpdate]),
+		counts[syncDelete], formatBytes(sizes[syncDelete]), unchanged)
+	if prune {
+		return 0
+	}
+	return counts[syncDelete]
+}
+
+// gitBlobID returns the git object id of a file, which the Hub lists as the oid of
+// files that are not stored in LFS.
+func gitBlobID(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	hasher := sha1.New()
+	fmt.Fprintf(hasher, "blob %d\x00", info.Size())
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
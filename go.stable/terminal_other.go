@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// terminalWidth cannot detect a terminal on this platform, so output is treated as redirected.
+func terminalWidth(f *os.File) (cols int, ok bool) {
+	return 0, false
+}
+
+// readSecret reads a line from f; echo cannot be turned off on this platform.
+func readSecret(f *os.File) (string, error) {
+	return readSecretLine(f)
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// terminalWidth returns the number of columns of the console window f is attached to.
+// ok is false if f is not a console (e.g. redirected to a file or a pipe).
+func terminalWidth(f *os.File) (cols int, ok bool) {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return 0, false
+	}
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(handle, &info); err != nil {
+		return 0, true
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, true
+}
+
+// readSecret reads a line from the console f with echo turned off.
+func readSecret(f *os.File) (string, error) {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return "", err
+	}
+	if err := windows.SetConsoleMode(handle, mode&^windows.ENABLE_ECHO_INPUT|windows.ENABLE_PROCESSED_INPUT|windows.ENABLE_LINE_INPUT); err != nil {
+		return "", err
+	}
+	defer windows.SetConsoleMode(handle, mode)
+	return readSecretLine(f)
+}
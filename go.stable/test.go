@@ -160,21 +160,11 @@ func printGPUInfo() { // Prints GPU Model and VRAM (Total)
 	switch runtime.GOOS {
 	case "linux":
 		// Attempt 1: nvidia-smi (for NVIDIA GPUs)
-		cmd = exec.Command("nvidia-smi", "--query-gpu=gpu_name,memory.total", "--format=csv,noheader,nounits")
-		output, err := cmd.Output()
+		gpus, err := queryNvidiaGPUs()
 		if err == nil {
-			lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-			for _, line := range lines {
-				if line == "" {
-					continue
-				}
-				parts := strings.Split(line, ",")
-				if len(parts) == 2 {
-					name := strings.TrimSpace(parts[0])
-					vramMB, _ := strconv.Atoi(strings.TrimSpace(parts[1])) // Already in MiB
-					gpuInfos = append(gpuInfos, fmt.Sprintf("%s (VRAM: %d MiB) [via nvidia-smi]", name, vramMB))
-					found = true
-				}
+			for _, gpu := range gpus {
+				gpuInfos = append(gpuInfos, fmt.Sprintf("%s (VRAM: %d MiB) [via nvidia-smi]", gpu.Name, gpu.TotalMiB))
+				found = true
 			}
 		} else {
 			appLogger.Printf("[SysInfo] nvidia-smi not found or failed: %v. Trying lspci.", err)
@@ -183,7 +173,7 @@ func printGPUInfo() { // Prints GPU Model and VRAM (Total)
 		// Attempt 2: lspci (generic, less VRAM detail)
 		if !found || len(gpuInfos) == 0 { // Try lspci if nvidia-smi failed or found nothing
 			cmd = exec.Command("lspci", "-vmm")
-			output, err = cmd.Output()
+			output, err := cmd.Output()
 			if err == nil {
 				currentDevice := make(map[string]string)
 				var lspci_gpus []string
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hashFileSHA256 returns the hex sha256 of the first n bytes of filePath, or of the
+// whole file if n is negative.
+func hashFileSHA256(filePath string, n int64) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if n < 0 {
+		_, err = io.Copy(hasher, f)
+	} else {
+		_, err = io.CopyN(hasher, f, n)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// sha256MismatchMsg returns a ProgressWriter error message if actual does not match
+// expected, or "" if they match or nothing was expected.
+func sha256MismatchMsg(expected string, actual string) string {
+	if expected == "" || strings.EqualFold(expected, actual) {
+		return ""
+	}
+	return fmt.Sprintf("SHA256 mismatch (expected %.12s, got %.12s)", expected, actual)
+}
+
+// HandleVerify re-hashes the files of a download directory against the metadata
+// recorded in its manifest. Files recorded without a sha256 are hashed and their
+// sha256 is recorded for the next run. It returns false if any file is missing or
+// corrupt.
+func HandleVerify(dir string) bool {
+	appLogger.Printf("[Verify] Verifying download directory: %s", dir)
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Could not read manifest in '%s': %v\n", dir, err)
+		return false
+	}
+	if len(manifest.Files) == 0 {
+		fmt.Fprintf(os.Stderr, "[ERROR] No recorded downloads found in '%s' (missing %s).\n", dir, manifestFileName)
+		return false
+	}
+
+	if manifest.Commit != "" {
+		fmt.Fprintf(os.Stderr, "[INFO] %s at commit %s (requested revision: %s)\n", manifest.Repo, manifest.Commit, manifest.Revision)
+	}
+
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var okCount, failCount, unverifiedCount, recorded int
+	for _, name := range names {
+		entry := manifest.Files[name]
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		info, statErr := os.Stat(filePath)
+		if statErr != nil {
+			fmt.Printf("MISSING   %s\n", name)
+			appLogger.Printf("[Verify] %s: %v", filePath, statErr)
+			failCount++
+			continue
+		}
+		if entry.Size > 0 && info.Size() != entry.Size {
+			fmt.Printf("BAD SIZE  %s (expected %s, found %s)\n", name, formatBytes(entry.Size), formatBytes(info.Size()))
+			failCount++
+			continue
+		}
+		_, stderrIsTerminal := terminalWidth(os.Stderr)
+		if stderrIsTerminal {
+			fmt.Fprintf(os.Stderr, "\rHashing %s (%s)...", name, formatBytes(info.Size()))
+		}
+		actual, hashErr := hashFileSHA256(filePath, -1)
+		if stderrIsTerminal {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+		}
+		if hashErr != nil {
+			fmt.Printf("ERROR     %s (%v)\n", name, hashErr)
+			failCount++
+			continue
+		}
+		if entry.SHA256 == "" {
+			// Nothing to check against yet: record the hash so the next run can.
+			entry.SHA256 = actual
+			manifest.Files[name] = entry
+			recorded++
+			fmt.Printf("SIZE OK   %s (no checksum recorded, sha256 recorded now)\n", name)
+			unverifiedCount++
+			continue
+		}
+		if msg := sha256MismatchMsg(entry.SHA256, actual); msg != "" {
+			fmt.Printf("CORRUPT   %s (%s)\n", name, msg)
+			appLogger.Printf("[Verify] %s: expected %s, got %s", filePath, entry.SHA256, actual)
+			failCount++
+			continue
+		}
+		fmt.Printf("OK        %s\n", name)
+		okCount++
+	}
+
+	if recorded > 0 {
+		if err := manifest.save(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Could not record the sha256 of %d file(s) in '%s': %v\n", recorded, dir, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\n[INFO] Verified %d file(s): %d OK, %d size-only, %d failed.\n", len(names), okCount, unverifiedCount, failCount)
+	appLogger.Printf("[Verify] Done: %d OK, %d size-only, %d failed.", okCount, unverifiedCount, failCount)
+	return failCount == 0
+}